@@ -0,0 +1,57 @@
+package quicklz
+
+import (
+    "fmt"
+)
+
+// AutoDecompressor decompresses blocks without being told how they were
+// compressed; the level and streaming buffer are read from the header of
+// the first block and every later block must agree with them
+type AutoDecompressor struct {
+    decompressor *Decompressor
+    level uint
+    streaming_buffer uint
+}
+
+// Create a decompressor that configures itself from the first block
+func NewAutoDecompressor() *AutoDecompressor {
+    return &AutoDecompressor{}
+}
+
+// Decompress the block in src and append the data to dst
+func (a *AutoDecompressor) AppendDecompress(dst, src []byte) ([]byte, error) {
+    h, err := ParseHeader(src)
+    if err != nil {
+        return dst, err
+    }
+    if a.decompressor == nil {
+        if h.StreamingBuffer == STREAMING_BUFFER_OTHER {
+            return dst, fmt.Errorf("%w: size is not recorded in the header", ErrInvalidStreamingBuffer)
+        }
+        d, err := NewDecompressor(h.Level, h.StreamingBuffer)
+        if err != nil {
+            return dst, err
+        }
+        a.decompressor = d
+        a.level = h.Level
+        a.streaming_buffer = h.StreamingBuffer
+    } else if h.Level != a.level {
+        return dst, ErrLevelMismatch
+    } else if h.StreamingBuffer != a.streaming_buffer {
+        return dst, ErrStreamingMismatch
+    }
+    return a.decompressor.AppendDecompress(dst, src)
+}
+
+// Decompress the block in src and return the data
+func (a *AutoDecompressor) Decompress(src []byte) ([]byte, error) {
+    return a.AppendDecompress(nil, src)
+}
+
+// Decompress a single block compressed with any level and streaming buffer
+//
+// The block must be the first one of its stream, or any block compressed
+// with STREAMING_BUFFER_0.
+func Decompress(src []byte) ([]byte, error) {
+    return NewAutoDecompressor().Decompress(src)
+}
@@ -0,0 +1,86 @@
+package quicklz
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+)
+
+func TestAutoDecompressor(t *testing.T) {
+    src := test_data(30000)
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        for _, buf := range []uint{STREAMING_BUFFER_0, STREAMING_BUFFER_100000, STREAMING_BUFFER_1000000} {
+            c, _ := NewCompressor(level, buf)
+            a := NewAutoDecompressor()
+            for i := 0; i < 3; i++ {
+                part := src[i*10000:(i+1)*10000]
+                block, err := c.AppendCompress(nil, part)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                out, err := a.Decompress(block)
+                if err != nil || !bytes.Equal(out, part) {
+                    t.Fatalf("level %d, buffer %d, block %d: %v", level, buf, i, err)
+                }
+            }
+        }
+    }
+}
+
+func TestAutoDecompressorMismatch(t *testing.T) {
+    src := test_data(1000)
+    first, _ := NewCompressor(COMPRESSION_LEVEL_1, STREAMING_BUFFER_100000)
+    block, _ := first.AppendCompress(nil, src)
+    level, _ := NewCompressor(COMPRESSION_LEVEL_2, STREAMING_BUFFER_100000)
+    other_level, _ := level.AppendCompress(nil, src)
+    buffer, _ := NewCompressor(COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+    other_buffer, _ := buffer.AppendCompress(nil, src)
+
+    a := NewAutoDecompressor()
+    if _, err := a.Decompress(block); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := a.Decompress(other_level); !errors.Is(err, ErrLevelMismatch) {
+        t.Errorf("other level: got %v, want ErrLevelMismatch", err)
+    }
+    if _, err := a.Decompress(other_buffer); !errors.Is(err, ErrStreamingMismatch) {
+        t.Errorf("other streaming buffer: got %v, want ErrStreamingMismatch", err)
+    }
+
+    // The rejected blocks did not disturb the stream
+    next := test_data(3000)[1000:]
+    block, _ = first.AppendCompress(nil, next)
+    out, err := a.Decompress(block)
+    if err != nil || !bytes.Equal(out, next) {
+        t.Fatalf("block after a mismatch: %v", err)
+    }
+}
+
+func TestAutoDecompressorOtherStreamingBuffer(t *testing.T) {
+    c, _ := NewCompressor(COMPRESSION_LEVEL_1, 64 << 10)
+    block, _ := c.AppendCompress(nil, test_data(1000))
+    if _, err := NewAutoDecompressor().Decompress(block); !errors.Is(err, ErrInvalidStreamingBuffer) {
+        t.Fatalf("got %v, want ErrInvalidStreamingBuffer", err)
+    }
+    if _, err := Decompress(block); !errors.Is(err, ErrInvalidStreamingBuffer) {
+        t.Fatalf("Decompress: got %v, want ErrInvalidStreamingBuffer", err)
+    }
+}
+
+func TestDecompress(t *testing.T) {
+    src := test_data(5000)
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        for _, buf := range []uint{STREAMING_BUFFER_0, STREAMING_BUFFER_100000} {
+            c, _ := NewCompressor(level, buf)
+            block, _ := c.AppendCompress(nil, src)
+            out, err := Decompress(block)
+            if err != nil || !bytes.Equal(out, src) {
+                t.Fatalf("level %d, buffer %d: %v", level, buf, err)
+            }
+        }
+    }
+    // A long header cut after 3 bytes
+    if _, err := Decompress([]byte{0x47, 9, 0}); !errors.Is(err, ErrTruncated) {
+        t.Fatalf("truncated header: got %v, want ErrTruncated", err)
+    }
+}
@@ -0,0 +1,47 @@
+package quicklz
+
+// Largest input Compress accepts in a single block; the 9 byte header
+// stores 32 bit sizes
+const MaxBlockSize = 0xffffffff - 400
+
+// Get the maximum size of the block Compress produces for n bytes of input,
+// which is also the size the destination buffer must have
+func CompressBound(n int) int {
+    if n == 0 {
+        return 3
+    }
+    header := 3
+    if n >= 216 {
+        header = 9
+    }
+    // compress_core writes a control word ahead of every 31 literals and
+    // only falls back to storing the data as is once it is past half of the
+    // input, so small inputs grow by a few control words while larger ones
+    // never get more than two control words ahead. The control words of the
+    // largest small input are kept for larger ones, so a buffer sized for n
+    // bytes also holds any shorter input. One more byte covers the 4 byte
+    // store used for 3 byte matches.
+    words := n
+    if words > 299 {
+        words = 299
+    }
+    extra := _CWORD_LEN * (1 + (words - 1) / 31) + 1
+    // compress_core never returns less than 9 bytes
+    if extra < 9 - n {
+        extra = 9 - n
+    }
+    return header + n + extra
+}
+
+// Get the maximum size of the output a Writer produces for n bytes of input
+// compressed in blocks of chunk bytes
+func StreamCompressBound(n int, chunk int) int {
+    if n <= 0 || chunk <= 0 {
+        return 0
+    }
+    bound := (n / chunk) * CompressBound(chunk)
+    if n % chunk != 0 {
+        bound += CompressBound(n % chunk)
+    }
+    return bound
+}
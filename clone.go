@@ -0,0 +1,63 @@
+package quicklz
+
+// Return an independent copy of q, history included
+//
+// A stream primed with a common prefix can be cloned for each downstream
+// stream instead of replaying the prefix through a new instance.
+func (q *Qlz) Clone() *Qlz {
+    z := Qlz{config: q.config}
+    if q.compressor != nil {
+        z.compressor = q.compressor.Clone()
+    }
+    if q.decompressor != nil {
+        z.decompressor = q.decompressor.Clone()
+    }
+    return &z
+}
+
+// Return an independent copy of q, history included
+func (q *Compressor) Clone() *Compressor {
+    s := q.state
+    state := state_compress{
+        stream_buffer: clone_bytes(s.stream_buffer),
+        stream_counter: s.stream_counter,
+        hash: make([]hash_compress, len(s.hash)),
+        hash_counter: clone_bytes(s.hash_counter),
+    }
+    for i := range s.hash {
+        state.hash[i] = s.hash[i]
+        state.hash[i].offset2 = clone_offsets(s.hash[i].offset2)
+    }
+    // The primed state is never modified and can be shared
+    return &Compressor{config: q.config, state: &state, primed: q.primed, primed_slots: q.primed_slots}
+}
+
+// Return an independent copy of q, history included
+func (q *Decompressor) Clone() *Decompressor {
+    s := q.state
+    state := state_decompress{
+        stream_buffer: clone_bytes(s.stream_buffer),
+        hash: make([]hash_decompress, len(s.hash)),
+        hash_counter: clone_bytes(s.hash_counter),
+        stream_counter: s.stream_counter,
+    }
+    for i := range s.hash {
+        state.hash[i] = s.hash[i]
+        state.hash[i].offset2 = clone_offsets(s.hash[i].offset2)
+    }
+    return &Decompressor{config: q.config, state: &state, primed: q.primed, primed_slots: q.primed_slots}
+}
+
+func clone_bytes(b []byte) []byte {
+    if b == nil {
+        return nil
+    }
+    return append(make([]byte, 0, len(b)), b...)
+}
+
+func clone_offsets(o []int64) []int64 {
+    if o == nil {
+        return nil
+    }
+    return append(make([]int64, 0, len(o)), o...)
+}
@@ -0,0 +1,69 @@
+package quicklz
+
+import (
+    "bytes"
+    "testing"
+)
+
+// Blocks a new instance produces for the parts
+func stream_blocks(t *testing.T, level uint, parts ...[]byte) [][]byte {
+    c, _ := NewCompressor(level, STREAMING_BUFFER_100000)
+    var blocks [][]byte
+    for _, p := range parts {
+        block, err := c.AppendCompress(nil, p)
+        if err != nil {
+            t.Fatal(err)
+        }
+        blocks = append(blocks, block)
+    }
+    return blocks
+}
+
+func TestCloneDiverges(t *testing.T) {
+    src := test_data(50000)
+    prefix := src[:10000]
+    a := [][]byte{src[10000:20000], src[20000:30000]}
+    b := [][]byte{src[30000:40000], src[40000:50000]}
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        q, _ := New(level, STREAMING_BUFFER_100000)
+        block, _ := q.AppendCompress(nil, prefix)
+        q.AppendDecompress(nil, block)
+        fork := q.Clone()
+
+        // Interleaved, so a shared buffer or table would show up
+        want_a := stream_blocks(t, level, prefix, a[0], a[1])[1:]
+        want_b := stream_blocks(t, level, prefix, b[0], b[1])[1:]
+        for i := range a {
+            block_a, err := q.AppendCompress(nil, a[i])
+            if err != nil || !bytes.Equal(block_a, want_a[i]) {
+                t.Fatalf("level %d, block %d: original differs after the clone diverged: %v", level, i, err)
+            }
+            block_b, err := fork.AppendCompress(nil, b[i])
+            if err != nil || !bytes.Equal(block_b, want_b[i]) {
+                t.Fatalf("level %d, block %d: clone differs after the original diverged: %v", level, i, err)
+            }
+            out, err := q.AppendDecompress(nil, block_a)
+            if err != nil || !bytes.Equal(out, a[i]) {
+                t.Fatalf("level %d, block %d: original decompressor: %v", level, i, err)
+            }
+            out, err = fork.AppendDecompress(nil, block_b)
+            if err != nil || !bytes.Equal(out, b[i]) {
+                t.Fatalf("level %d, block %d: cloned decompressor: %v", level, i, err)
+            }
+        }
+    }
+}
+
+func TestCloneUnusedSide(t *testing.T) {
+    // A side that was never allocated stays lazy in the clone
+    q, _ := New(COMPRESSION_LEVEL_1, STREAMING_BUFFER_100000)
+    block, _ := q.AppendCompress(nil, test_data(1000))
+    fork := q.Clone()
+    if fork.decompressor != nil {
+        t.Fatal("clone allocated the decompressor")
+    }
+    out, err := fork.AppendDecompress(nil, block)
+    if err != nil || !bytes.Equal(out, test_data(1000)) {
+        t.Fatal(err)
+    }
+}
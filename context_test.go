@@ -0,0 +1,87 @@
+package quicklz
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "math/rand"
+    "testing"
+)
+
+// A context that is already done but reports it only from the second call
+// to Err on, so the check on entry passes and only the core loops can stop
+type cancelled_late struct {
+    context.Context
+    calls int
+}
+
+func new_cancelled_late() *cancelled_late {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    return &cancelled_late{Context: ctx}
+}
+
+func (c *cancelled_late) Err() error {
+    c.calls++
+    if c.calls == 1 {
+        return nil
+    }
+    return c.Context.Err()
+}
+
+func TestContextInterruptsCore(t *testing.T) {
+    // Partly random so there are enough control words between the checks
+    src := test_data(8 << 20)
+    r := rand.New(rand.NewSource(1))
+    for i := 0; i < len(src); i += 8 {
+        src[i] = byte(r.Intn(256))
+    }
+    for _, buf := range []uint{STREAMING_BUFFER_0, STREAMING_BUFFER_1000000} {
+        part := src
+        if buf != STREAMING_BUFFER_0 {
+            part = src[:buf/2]
+        }
+        q, err := New(COMPRESSION_LEVEL_3, buf)
+        if err != nil {
+            t.Fatal(err)
+        }
+        // Some history for the cancellation to discard
+        prior := src[len(src)-20000:]
+        block, _ := q.AppendCompress(nil, prior)
+        q.AppendDecompress(nil, block)
+
+        dst := make([]byte, CompressBound(len(part)))
+        if _, err := q.CompressContext(new_cancelled_late(), &part, &dst); !errors.Is(err, context.Canceled) {
+            t.Fatalf("buffer %d: compress got %v, want context.Canceled", buf, err)
+        }
+
+        // The history was reset, the next block is the one a new instance
+        // produces
+        block, err = q.AppendCompress(nil, part)
+        if err != nil {
+            t.Fatal(err)
+        }
+        fresh, _ := NewCompressor(COMPRESSION_LEVEL_3, buf)
+        want, _ := fresh.AppendCompress(nil, part)
+        if !bytes.Equal(block, want) {
+            t.Fatalf("buffer %d: compressor not reset after cancellation", buf)
+        }
+
+        out := make([]byte, len(part))
+        if _, err := q.DecompressContext(new_cancelled_late(), &block, &out); !errors.Is(err, context.Canceled) {
+            t.Fatalf("buffer %d: decompress got %v, want context.Canceled", buf, err)
+        }
+        out, err = q.AppendDecompress(nil, block)
+        if err != nil || !bytes.Equal(out, part) {
+            t.Fatalf("buffer %d: decompressor not usable after cancellation: %v", buf, err)
+        }
+
+        // Both sides go on with the same history
+        next := src[len(part)/2:len(part)/2+1000]
+        block, _ = q.AppendCompress(nil, next)
+        out, err = q.AppendDecompress(nil, block)
+        if err != nil || !bytes.Equal(out, next) {
+            t.Fatalf("buffer %d: stream broken after cancellation: %v", buf, err)
+        }
+    }
+}
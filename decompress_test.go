@@ -0,0 +1,71 @@
+package quicklz
+
+import (
+    "bytes"
+    "errors"
+    "runtime"
+    "testing"
+)
+
+// Bytes allocated by f
+func allocated(f func()) uint64 {
+    var before, after runtime.MemStats
+    runtime.ReadMemStats(&before)
+    f()
+    runtime.ReadMemStats(&after)
+    return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestAppendDecompressTruncated(t *testing.T) {
+    // Header of a 1 MB block holding 200 MB, without the block
+    src := []byte{0x47, 0, 0, 0x10, 0, 0, 0, 0x80, 0x0c}
+    var err error
+    n := allocated(func() {
+        _, err = DecompressBlock(src)
+    })
+    if !errors.Is(err, ErrTruncated) {
+        t.Fatalf("got %v, want ErrTruncated", err)
+    }
+    if n > 1 << 20 {
+        t.Fatalf("allocated %d bytes for a truncated block", n)
+    }
+}
+
+func TestAppendDecompressStrictTruncated(t *testing.T) {
+    src := []byte{0x47, 0, 0, 0x10, 0, 0, 0, 0x80, 0x0c}
+    d, err := NewDecompressor(COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    n := allocated(func() {
+        _, _, err = d.AppendDecompressStrict(nil, src)
+    })
+    if !errors.Is(err, ErrTruncated) {
+        t.Fatalf("got %v, want ErrTruncated", err)
+    }
+    if n > 1 << 20 {
+        t.Fatalf("allocated %d bytes for a truncated block", n)
+    }
+}
+
+func TestParseHeaderExpansion(t *testing.T) {
+    // A 20 byte block cannot hold 4 GB of data
+    src := make([]byte, 20)
+    copy(src, []byte{0x47, 20, 0, 0, 0, 0xff, 0xff, 0xff, 0xf0})
+    if _, err := ParseHeader(src); !errors.Is(err, ErrCorrupt) {
+        t.Fatalf("got %v, want ErrCorrupt", err)
+    }
+
+    // The most compressible input stays within the limit
+    zeros := make([]byte, 1 << 20)
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        block, err := CompressBlock(level, zeros)
+        if err != nil {
+            t.Fatal(err)
+        }
+        out, err := DecompressBlock(block)
+        if err != nil || !bytes.Equal(out, zeros) {
+            t.Fatalf("level %d: %v", level, err)
+        }
+    }
+}
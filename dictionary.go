@@ -0,0 +1,111 @@
+package quicklz
+
+import (
+    "fmt"
+)
+
+// Create new compressor/decompressor primed with a preset dictionary
+//
+// Each block is compressed against the dictionary only, the history is
+// restored to the primed state after every call, so blocks can be
+// decompressed independently of each other. Blocks can refer back to the
+// dictionary as long as they are at most streaming_buffer - len(dict) bytes,
+// larger blocks are compressed without it. Both sides must use the same
+// level, streaming buffer and dictionary.
+func NewWithDictionary(compression_level uint, streaming_buffer uint, dict []byte) (*Qlz, error) {
+    c, block, err := new_primed_compressor(compression_level, streaming_buffer, dict)
+    if err != nil {
+        return nil, err
+    }
+    d, err := new_primed_decompressor(c.config, block, len(dict))
+    if err != nil {
+        return nil, err
+    }
+    return &Qlz{config: c.config, compressor: c, decompressor: d}, nil
+}
+
+// Create new compressor primed with a preset dictionary
+func NewCompressorWithDictionary(compression_level uint, streaming_buffer uint, dict []byte) (*Compressor, error) {
+    c, _, err := new_primed_compressor(compression_level, streaming_buffer, dict)
+    return c, err
+}
+
+// Create new decompressor primed with a preset dictionary
+func NewDecompressorWithDictionary(compression_level uint, streaming_buffer uint, dict []byte) (*Decompressor, error) {
+    c, block, err := new_primed_compressor(compression_level, streaming_buffer, dict)
+    if err != nil {
+        return nil, err
+    }
+    return new_primed_decompressor(c.config, block, len(dict))
+}
+
+// The dictionary is fed through the compressor like the first block of a
+// stream; decompressing that block leaves the decompressor in the matching
+// state, so both sides can be primed independently.
+func new_primed_compressor(compression_level uint, streaming_buffer uint, dict []byte) (*Compressor, []byte, error) {
+    c, err := NewCompressor(compression_level, streaming_buffer)
+    if err != nil {
+        return nil, nil, err
+    }
+    if streaming_buffer == STREAMING_BUFFER_0 || uint64(len(dict)) >= uint64(streaming_buffer) {
+        return nil, nil, fmt.Errorf("%w: the dictionary must be smaller than the streaming buffer", ErrInvalidStreamingBuffer)
+    }
+    block, err := c.AppendCompress(nil, dict)
+    if err != nil {
+        return nil, nil, err
+    }
+    c.primed = c.Clone().state
+    c.primed_slots = primed_slots(c.primed.hash_counter)
+    return c, block, nil
+}
+
+func new_primed_decompressor(cfg config, block []byte, n int) (*Decompressor, error) {
+    d := cfg.new_decompressor()
+    if _, err := d.AppendDecompress(make([]byte, 0, n), block); err != nil {
+        return nil, err
+    }
+    d.primed = d.Clone().state
+    d.primed_slots = primed_slots(d.primed.hash_counter)
+    return d, nil
+}
+
+func primed_slots(hash_counter []byte) []int {
+    var slots []int
+    for i, c := range hash_counter {
+        if c != 0 {
+            slots = append(slots, i)
+        }
+    }
+    return slots
+}
+
+// Only the hash slots with a non-zero counter are read before being written,
+// so restoring the counters and the offsets of the primed slots is enough;
+// level 1 tables are small and copied whole.
+func (q *Compressor) restore_primed() {
+    s, p := q.state, q.primed
+    s.stream_counter = p.stream_counter
+    if q._COMPRESSION_LEVEL == 1 {
+        copy(s.hash, p.hash)
+        return
+    }
+    copy(s.hash_counter, p.hash_counter)
+    for _, i := range q.primed_slots {
+        copy(s.hash[i].offset2, p.hash[i].offset2)
+    }
+}
+
+// The compressor never refers to a slot that was empty after priming, so
+// the offsets left in those by the last block are harmless.
+func (q *Decompressor) restore_primed() {
+    s, p := q.state, q.primed
+    s.stream_counter = p.stream_counter
+    if q._COMPRESSION_LEVEL == 3 {
+        return
+    }
+    copy(s.hash_counter, p.hash_counter)
+    for _, i := range q.primed_slots {
+        s.hash[i].offset = p.hash[i].offset
+        copy(s.hash[i].offset2, p.hash[i].offset2)
+    }
+}
@@ -0,0 +1,67 @@
+package quicklz
+
+import (
+    "bytes"
+    "testing"
+)
+
+// Every message must decompress on its own, whatever was decompressed
+// before it
+func TestDictionaryOutOfOrder(t *testing.T) {
+    dict := test_data(3000)
+    data := test_data(120000)
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        for _, buf := range []uint{STREAMING_BUFFER_100000, 20000} {
+            limit := int(buf) - len(dict)
+            sizes := []int{0, 1, 100, 2500, 9000, limit, limit + 1, int(buf) + 5000, 0, 700}
+            q, err := NewWithDictionary(level, buf, dict)
+            if err != nil {
+                t.Fatal(err)
+            }
+            var msgs, blocks [][]byte
+            for i, n := range sizes {
+                // Messages start at different places of the data
+                msg := data[i*97:i*97+n]
+                block, err := q.AppendCompress(nil, msg)
+                if err != nil {
+                    t.Fatalf("level %d, buffer %d, %d bytes: %v", level, buf, n, err)
+                }
+                msgs = append(msgs, msg)
+                blocks = append(blocks, block)
+            }
+
+            d, err := NewDecompressorWithDictionary(level, buf, dict)
+            if err != nil {
+                t.Fatal(err)
+            }
+            for _, order := range [][]int{{9, 8, 7, 6, 5, 4, 3, 2, 1, 0}, {4, 0, 7, 2, 6, 6, 1, 9, 3, 5, 8, 4}} {
+                for _, i := range order {
+                    out, err := d.AppendDecompress(nil, blocks[i])
+                    if err != nil || !bytes.Equal(out, msgs[i]) {
+                        t.Fatalf("level %d, buffer %d, message %d of %d bytes: %v", level, buf, i, len(msgs[i]), err)
+                    }
+                    out, err = q.AppendDecompress(nil, blocks[i])
+                    if err != nil || !bytes.Equal(out, msgs[i]) {
+                        t.Fatalf("level %d, buffer %d, message %d of %d bytes: %v", level, buf, i, len(msgs[i]), err)
+                    }
+                }
+            }
+        }
+    }
+}
+
+func TestDictionaryImprovesSmallMessages(t *testing.T) {
+    dict := test_data(3000)
+    msg := dict[1000:1400]
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        q, err := NewWithDictionary(level, STREAMING_BUFFER_100000, dict)
+        if err != nil {
+            t.Fatal(err)
+        }
+        primed, _ := q.AppendCompress(nil, msg)
+        plain, _ := CompressBlock(level, msg)
+        if len(primed) >= len(plain) {
+            t.Fatalf("level %d: %d bytes with the dictionary, %d without", level, len(primed), len(plain))
+        }
+    }
+}
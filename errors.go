@@ -0,0 +1,77 @@
+package quicklz
+
+import (
+    "errors"
+    "strconv"
+)
+
+var (
+    ErrInvalidLevel = errors.New("invalid compression level")
+    ErrInvalidStreamingBuffer = errors.New("invalid streaming buffer size")
+    ErrCorrupt = errors.New("corrupt input")
+    ErrShortBuffer = errors.New("buffer too short")
+    ErrInputTooLarge = errors.New("input too large")
+    ErrInvalidSize = errors.New("invalid size")
+    ErrInvalidOffset = errors.New("invalid offset")
+    ErrWriterStarted = errors.New("writer already started")
+    ErrLevelMismatch = errors.New("compression level does not match")
+    ErrStreamingMismatch = errors.New("streaming buffer does not match")
+    ErrTruncated = errors.New("truncated input")
+    ErrClosed = errors.New("use of closed writer")
+    ErrInvalidSnapshot = errors.New("invalid snapshot")
+    ErrSnapshotDictionary = errors.New("cannot snapshot a state primed with a dictionary")
+    ErrSequence = errors.New("block out of sequence")
+    ErrHistoryMismatch = errors.New("block compressed against a different history")
+    ErrInvalidFile = errors.New("not a qlz file")
+    ErrChecksum = errors.New("checksum mismatch")
+)
+
+// CorruptError is returned for malformed compressed data, errors.Is reports
+// it as ErrCorrupt
+type CorruptError struct {
+    Offset int64   // offset in the block where the corruption was detected
+    Reason string
+}
+
+func (e *CorruptError) Error() string {
+    return "corrupt input at offset " + strconv.FormatInt(e.Offset, 10) + ": " + e.Reason
+}
+
+func (e *CorruptError) Is(target error) bool {
+    return target == ErrCorrupt
+}
+
+// SequenceError is returned for a frame that does not carry the expected
+// sequence number, errors.Is reports it as ErrSequence
+type SequenceError struct {
+    Expected uint64
+    Got uint64
+}
+
+func (e *SequenceError) Error() string {
+    return "block out of sequence: expected " + strconv.FormatUint(e.Expected, 10) + ", got " + strconv.FormatUint(e.Got, 10)
+}
+
+func (e *SequenceError) Is(target error) bool {
+    return target == ErrSequence
+}
+
+// ChecksumError is returned when the data does not match its checksum,
+// errors.Is reports it as ErrChecksum
+type ChecksumError struct {
+    Block int64   // index of the block, -1 for the whole content
+    Expected uint32
+    Got uint32
+}
+
+func (e *ChecksumError) Error() string {
+    where := "content"
+    if e.Block >= 0 {
+        where = "block " + strconv.FormatInt(e.Block, 10)
+    }
+    return "checksum mismatch in " + where + ": expected " + strconv.FormatUint(uint64(e.Expected), 16) + ", got " + strconv.FormatUint(uint64(e.Got), 16)
+}
+
+func (e *ChecksumError) Is(target error) bool {
+    return target == ErrChecksum
+}
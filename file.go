@@ -0,0 +1,431 @@
+package quicklz
+
+import (
+    "encoding/binary"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "io/ioutil"
+)
+
+// The .qlz file format, all integers little endian:
+//
+//   header   magic 0x89 'Q' 'L' 'Z', version (1 byte), flags (1 byte),
+//            compression level (1 byte), streaming buffer (8 bytes)
+//   frames   standard QuickLZ blocks as returned by Compress, each followed
+//            by the CRC-32C of the block (4 bytes) with FLAG_BLOCK_CHECKSUM,
+//            and skippable frames
+//   metadata frame type 0x01, payload length (4 bytes) and key/value pairs,
+//            each string preceded by its length (4 bytes)
+//   index    with FLAG_INDEX, frame type 0x02, payload length (4 bytes) and
+//            for each block its offset in the file and its offset in the
+//            uncompressed data (8 bytes each)
+//   end      frame type 0x00
+//   trailer  total uncompressed size (8 bytes), block count (8 bytes),
+//            CRC-32C of the uncompressed data (4 bytes) with
+//            FLAG_CONTENT_CHECKSUM, offset of the index frame (8 bytes) with
+//            FLAG_INDEX
+//
+// Every block header has bit 6 of its first byte set, every other frame
+// starts with a type byte that has it clear. Frame types 0x01 to 0x3f are
+// skippable: a payload length (4 bytes) follows the type, and readers skip
+// the types they do not know.
+//
+// The streaming buffer of a file is at most MaxFileStreamingBuffer, readers
+// reject larger values and grow the history as the data arrives, so a
+// header alone does not make them allocate the streaming buffer.
+const FileVersion = 1
+
+// Largest streaming buffer a .qlz file may be compressed with
+const MaxFileStreamingBuffer = MaxBlockSize
+
+const _FILE_HEADER_SIZE = 15
+
+// Flags of the file header
+const (
+    FLAG_BLOCK_CHECKSUM = 1 << 0
+    FLAG_CONTENT_CHECKSUM = 1 << 1
+    FLAG_INDEX = 1 << 2
+)
+
+const _FILE_FLAGS = FLAG_BLOCK_CHECKSUM | FLAG_CONTENT_CHECKSUM | FLAG_INDEX
+
+const (
+    _FRAME_END = 0x00
+    _FRAME_METADATA = 0x01
+    _FRAME_INDEX = 0x02
+    _FRAME_SKIPPABLE_LAST = 0x3f
+)
+
+var file_magic = []byte{0x89, 'Q', 'L', 'Z'}
+
+// FileWriter writes a .qlz file
+type FileWriter struct {
+    writer *Writer
+    w io.Writer
+    level uint
+    streaming_buffer uint
+    flags byte
+    started bool
+    size uint64
+    blocks uint64
+    checksum uint32
+    offset uint64    // bytes written so far
+    index []uint64   // file and uncompressed offset of each block with FLAG_INDEX
+}
+
+// FileReader reads a .qlz file, the configuration is taken from its header
+type FileReader struct {
+    reader *Reader
+    level uint
+    streaming_buffer uint
+    flags byte
+    size uint64
+    blocks uint64
+    checksum uint32
+    metadata func(md map[string]string)
+    multistream bool
+}
+
+// Create a writer of a .qlz file compressed with the given configuration
+func NewFileWriter(w io.Writer, compression_level uint, streaming_buffer uint) (*FileWriter, error) {
+    if uint64(streaming_buffer) > MaxFileStreamingBuffer {
+        return nil, fmt.Errorf("%w (%d)", ErrInvalidStreamingBuffer, streaming_buffer)
+    }
+    writer, err := NewWriter(w, compression_level, streaming_buffer)
+    if err != nil {
+        return nil, err
+    }
+    z := FileWriter{
+        writer: writer,
+        w: w,
+        level: compression_level,
+        streaming_buffer: streaming_buffer,
+    }
+    writer.block = z.write_block
+    return &z, nil
+}
+
+// Enable the CRC-32C of each compressed block and of the whole uncompressed
+// content; it must be called before anything is written
+func (z *FileWriter) SetChecksums(block, content bool) error {
+    if z.started {
+        return fmt.Errorf("%w: checksums must be set before the first write", ErrWriterStarted)
+    }
+    z.flags &^= FLAG_BLOCK_CHECKSUM | FLAG_CONTENT_CHECKSUM
+    if block {
+        z.flags |= FLAG_BLOCK_CHECKSUM
+    }
+    if content {
+        z.flags |= FLAG_CONTENT_CHECKSUM
+    }
+    return nil
+}
+
+// The header is written with the first data, options set before that are
+// recorded in its flags
+func (z *FileWriter) start() error {
+    if z.started {
+        return nil
+    }
+    z.started = true
+    header := make([]byte, 0, _FILE_HEADER_SIZE)
+    header = append(header, file_magic...)
+    header = append(header, FileVersion, z.flags, byte(z.level))
+    header = append_uint64(header, uint64(z.streaming_buffer))
+    if _, err := z.w.Write(header); err != nil {
+        z.writer.err = err
+        return err
+    }
+    z.offset = _FILE_HEADER_SIZE
+    return nil
+}
+
+func (z *FileWriter) write_block(src, block []byte) error {
+    if (z.flags & FLAG_INDEX) != 0 {
+        z.index = append(z.index, z.offset, z.size)
+    }
+    z.offset += uint64(len(block))
+    z.size += uint64(len(src))
+    z.blocks++
+    if (z.flags & FLAG_CONTENT_CHECKSUM) != 0 {
+        z.checksum = crc32.Update(z.checksum, crc32c, src)
+    }
+    if (z.flags & FLAG_BLOCK_CHECKSUM) != 0 {
+        var sum [4]byte
+        binary.LittleEndian.PutUint32(sum[:], crc32.Checksum(block, crc32c))
+        if _, err := z.w.Write(sum[:]); err != nil {
+            return err
+        }
+        z.offset += 4
+    }
+    return nil
+}
+
+// Write compresses p into the file
+func (z *FileWriter) Write(p []byte) (int, error) {
+    if !z.writer.closed {
+        if err := z.start(); err != nil {
+            return 0, err
+        }
+    }
+    return z.writer.Write(p)
+}
+
+// Flush compresses any buffered data and writes it out as a block
+func (z *FileWriter) Flush() error {
+    if !z.writer.closed {
+        if err := z.start(); err != nil {
+            return err
+        }
+    }
+    return z.writer.Flush()
+}
+
+// Close writes the remaining data and the trailer; it does not close the
+// underlying writer
+//
+// Once an error occurred every later call to Close returns it again.
+func (z *FileWriter) Close() error {
+    if z.writer.closed {
+        return z.writer.err
+    }
+    if err := z.start(); err != nil {
+        return err
+    }
+    if err := z.writer.Close(); err != nil {
+        return err
+    }
+    index_offset := z.offset
+    if (z.flags & FLAG_INDEX) != 0 {
+        if err := z.write_index(); err != nil {
+            z.writer.err = err
+            return err
+        }
+    }
+    trailer := make([]byte, 0, 29)
+    trailer = append(trailer, _FRAME_END)
+    trailer = append_uint64(trailer, z.size)
+    trailer = append_uint64(trailer, z.blocks)
+    if (z.flags & FLAG_CONTENT_CHECKSUM) != 0 {
+        trailer = append_uint32(trailer, z.checksum)
+    }
+    if (z.flags & FLAG_INDEX) != 0 {
+        trailer = append_uint64(trailer, index_offset)
+    }
+    if _, err := z.w.Write(trailer); err != nil {
+        z.writer.err = err
+        return err
+    }
+    return nil
+}
+
+func (z *FileWriter) write_index() error {
+    if uint64(len(z.index)) > 0xffffffff / 8 {
+        return fmt.Errorf("%w: too many blocks for the index", ErrInputTooLarge)
+    }
+    payload := make([]byte, 0, 8 * len(z.index))
+    for _, o := range z.index {
+        payload = append_uint64(payload, o)
+    }
+    return z.write_frame(_FRAME_INDEX, payload)
+}
+
+// Write a skippable frame
+func (z *FileWriter) write_frame(t byte, payload []byte) error {
+    frame := make([]byte, 0, 5 + len(payload))
+    frame = append(frame, t)
+    frame = append_uint32(frame, uint32(len(payload)))
+    frame = append(frame, payload...)
+    if _, err := z.w.Write(frame); err != nil {
+        z.writer.err = err
+        return err
+    }
+    z.offset += uint64(len(frame))
+    return nil
+}
+
+// Create a reader of a .qlz file, reading its header from r
+func NewFileReader(r io.Reader) (*FileReader, error) {
+    header := make([]byte, _FILE_HEADER_SIZE)
+    if _, err := io.ReadFull(r, header); err != nil {
+        return nil, unexpected_eof(err)
+    }
+    z := FileReader{}
+    var err error
+    z.flags, z.level, z.streaming_buffer, err = parse_file_header(header)
+    if err != nil {
+        return nil, err
+    }
+    reader, err := NewReader(r, z.level, z.streaming_buffer)
+    if err != nil {
+        return nil, err
+    }
+    reader.frame = z.read_frame
+    reader.check = z.check_block
+    reader.block = z.read_block
+    z.reader = reader
+    return &z, nil
+}
+
+func parse_file_header(header []byte) (byte, uint, uint, error) {
+    if string(header[:4]) != string(file_magic) {
+        return 0, 0, 0, ErrInvalidFile
+    }
+    if header[4] != FileVersion {
+        return 0, 0, 0, fmt.Errorf("%w: unsupported version %d", ErrInvalidFile, header[4])
+    }
+    flags := header[5]
+    if (flags &^ _FILE_FLAGS) != 0 {
+        return 0, 0, 0, fmt.Errorf("%w: unknown flags %#x", ErrInvalidFile, flags)
+    }
+    // No decompressor can be configured with a larger streaming buffer
+    buf := binary.LittleEndian.Uint64(header[7:15])
+    if buf > MaxFileStreamingBuffer || uint64(uint(buf)) != buf {
+        return 0, 0, 0, fmt.Errorf("%w: streaming buffer %d", ErrInvalidFile, buf)
+    }
+    return flags, uint(header[6]), uint(buf), nil
+}
+
+// Decode the files concatenated in the input one after the other, each with
+// its own configuration and a fresh history; by default reading stops after
+// the trailer of the first one
+//
+// It must be called before the first Read.
+func (z *FileReader) MultiStream(enable bool) {
+    z.multistream = enable
+}
+
+// Start the next file of a concatenation, or end the stream
+func (z *FileReader) next_member() error {
+    header := make([]byte, _FILE_HEADER_SIZE)
+    if _, err := io.ReadFull(z.reader.r, header[:1]); err != nil {
+        return err
+    }
+    if _, err := io.ReadFull(z.reader.r, header[1:]); err != nil {
+        return unexpected_eof(err)
+    }
+    // The same limits as for the first file apply before Reconfigure
+    // allocates the streaming buffer of the next one
+    flags, level, buf, err := parse_file_header(header)
+    if err != nil {
+        return err
+    }
+    if err := z.reader.decompressor.Reconfigure(level, buf); err != nil {
+        return err
+    }
+    z.flags, z.level, z.streaming_buffer = flags, level, buf
+    z.size, z.blocks, z.checksum = 0, 0, 0
+    return nil
+}
+
+// Compression level recorded in the file header
+func (z *FileReader) Level() uint {
+    return z.level
+}
+
+// Streaming buffer recorded in the file header
+func (z *FileReader) StreamingBuffer() uint {
+    return z.streaming_buffer
+}
+
+// Read decompresses data into p, returning io.EOF after a valid trailer
+func (z *FileReader) Read(p []byte) (int, error) {
+    return z.reader.Read(p)
+}
+
+// The checksum follows the block, it is verified before the block gets into
+// the history
+func (z *FileReader) check_block(src []byte) error {
+    if (z.flags & FLAG_BLOCK_CHECKSUM) == 0 {
+        return nil
+    }
+    var sum [4]byte
+    if _, err := io.ReadFull(z.reader.r, sum[:]); err != nil {
+        return unexpected_eof(err)
+    }
+    expected := binary.LittleEndian.Uint32(sum[:])
+    if got := crc32.Checksum(src, crc32c); got != expected {
+        return &ChecksumError{Block: int64(z.blocks), Expected: expected, Got: got}
+    }
+    return nil
+}
+
+func (z *FileReader) read_block(src, out []byte) error {
+    z.size += uint64(len(out))
+    z.blocks++
+    if (z.flags & FLAG_CONTENT_CHECKSUM) != 0 {
+        z.checksum = crc32.Update(z.checksum, crc32c, out)
+    }
+    return nil
+}
+
+func (z *FileReader) read_frame(t byte) error {
+    switch t {
+    case _FRAME_END:
+        return z.read_trailer()
+    case _FRAME_METADATA:
+        if z.metadata != nil {
+            return z.read_metadata()
+        }
+    case file_magic[0]:
+        // The next file of a concatenation starts before this one ended
+        return ErrTruncated
+    }
+    if t <= _FRAME_SKIPPABLE_LAST {
+        return z.skip_frame()
+    }
+    return &CorruptError{0, fmt.Sprintf("unknown frame type %#x", t)}
+}
+
+func (z *FileReader) read_frame_size() (int64, error) {
+    var n [4]byte
+    if _, err := io.ReadFull(z.reader.r, n[:]); err != nil {
+        return 0, unexpected_eof(err)
+    }
+    return int64(binary.LittleEndian.Uint32(n[:])), nil
+}
+
+// Skip a frame made of a 4 byte length and its payload
+func (z *FileReader) skip_frame() error {
+    n, err := z.read_frame_size()
+    if err != nil {
+        return err
+    }
+    if _, err := io.CopyN(ioutil.Discard, z.reader.r, n); err != nil {
+        return unexpected_eof(err)
+    }
+    return nil
+}
+
+func (z *FileReader) read_trailer() error {
+    trailer := make([]byte, trailer_size(z.flags) - 1)
+    if _, err := io.ReadFull(z.reader.r, trailer); err != nil {
+        return unexpected_eof(err)
+    }
+    if binary.LittleEndian.Uint64(trailer[0:8]) != z.size ||
+        binary.LittleEndian.Uint64(trailer[8:16]) != z.blocks {
+        return &CorruptError{0, "trailer does not match the data"}
+    }
+    if (z.flags & FLAG_CONTENT_CHECKSUM) != 0 {
+        if expected := binary.LittleEndian.Uint32(trailer[16:20]); expected != z.checksum {
+            return &ChecksumError{Block: -1, Expected: expected, Got: z.checksum}
+        }
+    }
+    if z.multistream {
+        return z.next_member()
+    }
+    return io.EOF
+}
+
+// Size of the end frame and the trailer
+func trailer_size(flags byte) int {
+    n := 1 + 16
+    if (flags & FLAG_CONTENT_CHECKSUM) != 0 {
+        n += 4
+    }
+    if (flags & FLAG_INDEX) != 0 {
+        n += 8
+    }
+    return n
+}
@@ -0,0 +1,346 @@
+package quicklz
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "io/ioutil"
+    "testing"
+)
+
+type file_options struct {
+    level uint
+    streaming_buffer uint
+    block_checksum bool
+    content_checksum bool
+}
+
+func write_file(t *testing.T, o file_options, parts ...[]byte) []byte {
+    var buf bytes.Buffer
+    w, err := NewFileWriter(&buf, o.level, o.streaming_buffer)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := w.SetChecksums(o.block_checksum, o.content_checksum); err != nil {
+        t.Fatal(err)
+    }
+    for _, p := range parts {
+        if _, err := w.Write(p); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := w.Close(); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+func read_file(file []byte) ([]byte, error) {
+    r, err := NewFileReader(bytes.NewReader(file))
+    if err != nil {
+        return nil, err
+    }
+    return ioutil.ReadAll(r)
+}
+
+func TestFileRoundTrip(t *testing.T) {
+    src := test_data(300000)
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        for _, buf := range []uint{STREAMING_BUFFER_0, STREAMING_BUFFER_100000, STREAMING_BUFFER_1000000, 50000} {
+            for _, checksums := range []bool{false, true} {
+                o := file_options{level, buf, checksums, checksums}
+                for _, n := range []int{0, 1, 1000, len(src)} {
+                    file := write_file(t, o, src[:n/2], src[n/2:n])
+                    r, err := NewFileReader(bytes.NewReader(file))
+                    if err != nil {
+                        t.Fatal(err)
+                    }
+                    if r.Level() != level || r.StreamingBuffer() != buf {
+                        t.Fatalf("%+v: header records level %d, buffer %d", o, r.Level(), r.StreamingBuffer())
+                    }
+                    out, err := ioutil.ReadAll(r)
+                    if err != nil || !bytes.Equal(out, src[:n]) {
+                        t.Fatalf("%+v, %d bytes: %v", o, n, err)
+                    }
+                }
+            }
+        }
+    }
+}
+
+func TestFileErrors(t *testing.T) {
+    src := test_data(200000)
+    o := file_options{COMPRESSION_LEVEL_1, STREAMING_BUFFER_100000, true, true}
+    file := write_file(t, o, src)
+
+    flipped := append([]byte(nil), file...)
+    flipped[_FILE_HEADER_SIZE + 100] ^= 0x10
+    truncated_header := file[:_FILE_HEADER_SIZE - 1]
+
+    tests := []struct {
+        name string
+        file []byte
+        err error
+    }{
+        {"not a qlz file", []byte("plain text, no magic number"), ErrInvalidFile},
+        {"truncated header", truncated_header, ErrTruncated},
+        {"truncated trailer", file[:len(file) - 3], ErrTruncated},
+        {"missing trailer", file[:len(file) - trailer_size(FLAG_BLOCK_CHECKSUM | FLAG_CONTENT_CHECKSUM)], ErrTruncated},
+        {"flipped bit in a block", flipped, ErrChecksum},
+    }
+    for _, tt := range tests {
+        out, err := read_file(tt.file)
+        if !errors.Is(err, tt.err) {
+            t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+        }
+        if len(out) > 0 && !bytes.Equal(out, src[:len(out)]) {
+            t.Errorf("%s: corrupt data returned", tt.name)
+        }
+    }
+
+    // Without checksums the content still has to match the trailer
+    o = file_options{COMPRESSION_LEVEL_2, STREAMING_BUFFER_0, false, true}
+    file = write_file(t, o, src)
+    file[len(file) - 1] ^= 1
+    if _, err := read_file(file); !errors.Is(err, ErrChecksum) {
+        t.Errorf("content checksum: got %v, want ErrChecksum", err)
+    }
+}
+
+func TestFileHostileStreamingBuffer(t *testing.T) {
+    for _, buf := range []uint64{1 << 62, 1 << 36, MaxFileStreamingBuffer + 1} {
+        header := append([]byte(nil), file_magic...)
+        header = append(header, FileVersion, 0, COMPRESSION_LEVEL_1)
+        header = append_uint64(header, buf)
+        var err error
+        n := allocated(func() {
+            _, err = NewFileReader(bytes.NewReader(header))
+        })
+        if !errors.Is(err, ErrInvalidFile) {
+            t.Errorf("streaming buffer %d: got %v, want ErrInvalidFile", buf, err)
+        }
+        if n > 1 << 20 {
+            t.Errorf("streaming buffer %d: allocated %d bytes", buf, n)
+        }
+    }
+}
+
+// The history grows with the data, a header declaring the largest streaming
+// buffer does not allocate it
+func TestFileLargeStreamingBuffer(t *testing.T) {
+    file := append([]byte(nil), file_magic...)
+    file = append(file, FileVersion, 0, COMPRESSION_LEVEL_1)
+    file = append_uint64(file, MaxFileStreamingBuffer)
+    file = append(file, _FRAME_END)
+    file = append_uint64(file, 0)
+    file = append_uint64(file, 0)
+    var out []byte
+    var err error
+    n := allocated(func() {
+        out, err = read_file(file)
+    })
+    if err != nil || len(out) != 0 {
+        t.Fatalf("got %d bytes with %v", len(out), err)
+    }
+    if n > 1 << 20 {
+        t.Fatalf("allocated %d bytes", n)
+    }
+}
+
+func TestFileChecksumsAfterWrite(t *testing.T) {
+    w, err := NewFileWriter(ioutil.Discard, COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    w.Write([]byte("data"))
+    if err := w.SetChecksums(true, true); !errors.Is(err, ErrWriterStarted) {
+        t.Fatalf("got %v, want ErrWriterStarted", err)
+    }
+}
+
+func TestFileMetadata(t *testing.T) {
+    src := test_data(200000)
+    var buf bytes.Buffer
+    w, err := NewFileWriter(&buf, COMPRESSION_LEVEL_1, STREAMING_BUFFER_100000)
+    if err != nil {
+        t.Fatal(err)
+    }
+    w.SetChecksums(true, true)
+    w.Write(src[:1000])
+    if err := w.WriteMetadata(map[string]string{"content-type": "text/plain", "host": "a"}); err != nil {
+        t.Fatal(err)
+    }
+    w.Write(src[1000:])
+    w.Close()
+    file := buf.Bytes()
+
+    // Without a handler the frame is skipped
+    out, err := read_file(file)
+    if err != nil || !bytes.Equal(out, src) {
+        t.Fatal(err)
+    }
+
+    r, err := NewFileReader(bytes.NewReader(file))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var got []map[string]string
+    var at []int
+    out = out[:0]
+    r.SetMetadataHandler(func(md map[string]string) {
+        got = append(got, md)
+        at = append(at, len(out))
+    })
+    p := make([]byte, 512)
+    for {
+        n, err := r.Read(p)
+        out = append(out, p[:n]...)
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    if !bytes.Equal(out, src) {
+        t.Fatal("data does not match")
+    }
+    if len(got) != 1 || got[0]["content-type"] != "text/plain" || got[0]["host"] != "a" || at[0] != 1000 {
+        t.Fatalf("got metadata %v at %v", got, at)
+    }
+}
+
+func TestFileMetadataTooLarge(t *testing.T) {
+    w, _ := NewFileWriter(ioutil.Discard, COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+    md := map[string]string{"big": string(make([]byte, MaxMetadataSize))}
+    if err := w.WriteMetadata(md); !errors.Is(err, ErrInputTooLarge) {
+        t.Fatalf("got %v, want ErrInputTooLarge", err)
+    }
+}
+
+func TestFileUnknownSkippableFrame(t *testing.T) {
+    src := test_data(5000)
+    var buf bytes.Buffer
+    w, _ := NewFileWriter(&buf, COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+    w.Write(src[:100])
+    w.Flush()
+    w.write_frame(_FRAME_SKIPPABLE_LAST, []byte("from a later version"))
+    w.Write(src[100:])
+    w.Close()
+    out, err := read_file(buf.Bytes())
+    if err != nil || !bytes.Equal(out, src) {
+        t.Fatal(err)
+    }
+}
+
+func TestFileMultiStream(t *testing.T) {
+    a := test_data(150000)
+    b := test_data(70000)
+    fa := write_file(t, file_options{COMPRESSION_LEVEL_1, STREAMING_BUFFER_100000, true, true}, a)
+    fb := write_file(t, file_options{COMPRESSION_LEVEL_3, STREAMING_BUFFER_1000000, false, false}, b)
+    both := append(append([]byte(nil), fa...), fb...)
+
+    r, _ := NewFileReader(bytes.NewReader(both))
+    r.MultiStream(true)
+    out, err := ioutil.ReadAll(r)
+    if err != nil || !bytes.Equal(out, append(append([]byte(nil), a...), b...)) {
+        t.Fatalf("multistream: %v", err)
+    }
+
+    // By default reading stops after the first file
+    out, err = read_file(both)
+    if err != nil || !bytes.Equal(out, a) {
+        t.Fatalf("single stream: %v", err)
+    }
+
+    // The second file starts where the first one has no trailer
+    cut := append(append([]byte(nil), fa[:len(fa) - trailer_size(FLAG_BLOCK_CHECKSUM | FLAG_CONTENT_CHECKSUM)]...), fb...)
+    r, _ = NewFileReader(bytes.NewReader(cut))
+    r.MultiStream(true)
+    if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrTruncated) {
+        t.Fatalf("got %v, want ErrTruncated", err)
+    }
+}
+
+func TestFileMultiStreamHostileMember(t *testing.T) {
+    a := test_data(5000)
+    fa := write_file(t, file_options{COMPRESSION_LEVEL_1, STREAMING_BUFFER_0, false, false}, a)
+    header := append([]byte(nil), file_magic...)
+    header = append(header, FileVersion, 0, COMPRESSION_LEVEL_1)
+    header = append_uint64(header, 1 << 62)
+    both := append(append([]byte(nil), fa...), header...)
+
+    r, _ := NewFileReader(bytes.NewReader(both))
+    r.MultiStream(true)
+    var out []byte
+    var err error
+    n := allocated(func() {
+        out, err = ioutil.ReadAll(r)
+    })
+    if !errors.Is(err, ErrInvalidFile) || !bytes.Equal(out, a) {
+        t.Fatalf("got %d bytes with %v, want the first file and ErrInvalidFile", len(out), err)
+    }
+    if n > 1 << 20 {
+        t.Fatalf("allocated %d bytes", n)
+    }
+}
+
+func TestFileMultiStreamLargeMember(t *testing.T) {
+    a := test_data(5000)
+    fa := write_file(t, file_options{COMPRESSION_LEVEL_1, STREAMING_BUFFER_0, false, false}, a)
+    member := append([]byte(nil), file_magic...)
+    member = append(member, FileVersion, 0, COMPRESSION_LEVEL_2)
+    member = append_uint64(member, MaxFileStreamingBuffer)
+    member = append(member, _FRAME_END)
+    member = append_uint64(member, 0)
+    member = append_uint64(member, 0)
+    both := append(append([]byte(nil), fa...), member...)
+
+    r, _ := NewFileReader(bytes.NewReader(both))
+    r.MultiStream(true)
+    var out []byte
+    var err error
+    n := allocated(func() {
+        out, err = ioutil.ReadAll(r)
+    })
+    if err != nil || !bytes.Equal(out, a) {
+        t.Fatalf("got %d bytes with %v", len(out), err)
+    }
+    if r.StreamingBuffer() != MaxFileStreamingBuffer {
+        t.Fatalf("second member not reached")
+    }
+    if n > 1 << 20 {
+        t.Fatalf("allocated %d bytes", n)
+    }
+}
+
+// Fails once more than n bytes were written
+type limited_writer struct {
+    n int
+}
+
+func (w *limited_writer) Write(p []byte) (int, error) {
+    if len(p) > w.n {
+        return 0, write_error
+    }
+    w.n -= len(p)
+    return len(p), nil
+}
+
+func TestFileCloseError(t *testing.T) {
+    src := test_data(5000)
+    o := file_options{COMPRESSION_LEVEL_1, STREAMING_BUFFER_0, true, true}
+    size := len(write_file(t, o, src))
+    // Fail in the data and in the trailer
+    for _, n := range []int{_FILE_HEADER_SIZE + 10, size - 1} {
+        w, _ := NewFileWriter(&limited_writer{n}, o.level, o.streaming_buffer)
+        w.SetChecksums(o.block_checksum, o.content_checksum)
+        w.Write(src)
+        if err := w.Close(); err != write_error {
+            t.Fatalf("limit %d: Close got %v, want the write error", n, err)
+        }
+        if err := w.Close(); err != write_error {
+            t.Fatalf("limit %d: second Close got %v, want the write error", n, err)
+        }
+    }
+}
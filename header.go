@@ -0,0 +1,90 @@
+package quicklz
+
+import (
+    "encoding/binary"
+)
+
+// Streaming buffer of a block compressed with a size other than the
+// predefined ones; the header does not record the actual size
+const STREAMING_BUFFER_OTHER = ^uint(0)
+
+// A match codes at most 255 bytes in 3, so no block decompresses to more
+// than about 85 times its size; the limit leaves a wide margin
+const _MAX_EXPANSION = 256
+
+// Header holds the fields of a QuickLZ block header
+type Header struct {
+    Compressed bool        // false if the data is stored as is
+    HeaderLen int          // 3 or 9 bytes
+    CompressedSize int     // size of the whole block, header included
+    DecompressedSize int
+    Level uint
+    StreamingBuffer uint   // STREAMING_BUFFER_OTHER for non-standard sizes
+}
+
+// Parse the header of the block at the start of src
+func ParseHeader(src []byte) (Header, error) {
+    var h Header
+    if len(src) == 0 {
+        return h, ErrTruncated
+    }
+    flags := src[0]
+    if (flags & (1 << 6)) == 0 || (flags & (1 << 7)) != 0 {
+        return h, &CorruptError{0, "invalid block header"}
+    }
+    h.Compressed = (flags & 1) == 1
+    if (flags & 2) == 2 {
+        h.HeaderLen = 9
+    } else {
+        h.HeaderLen = 3
+    }
+    h.Level = uint(flags >> 2) & 3
+    if h.Level == 0 {
+        return h, &CorruptError{0, "invalid compression level"}
+    }
+    h.StreamingBuffer = streaming_size((flags >> 4) & 3)
+
+    if len(src) < h.HeaderLen {
+        return h, ErrTruncated
+    }
+    if h.HeaderLen == 9 {
+        h.CompressedSize = int(binary.LittleEndian.Uint32(src[1:5]))
+        h.DecompressedSize = int(binary.LittleEndian.Uint32(src[5:9]))
+    } else {
+        h.CompressedSize = int(src[1])
+        h.DecompressedSize = int(src[2])
+    }
+    if h.CompressedSize < h.HeaderLen ||
+        (!h.Compressed && h.CompressedSize != h.HeaderLen + h.DecompressedSize) {
+        return h, &CorruptError{1, "invalid block size in header"}
+    }
+    if h.Compressed && int64(h.DecompressedSize) > int64(h.CompressedSize - h.HeaderLen) * _MAX_EXPANSION {
+        return h, &CorruptError{1, "decompressed size too large for the block"}
+    }
+    return h, nil
+}
+
+// Streaming buffer code stored in bits 4-5 of the first header byte
+func streaming_code(streaming_buffer uint) byte {
+    switch streaming_buffer {
+    case STREAMING_BUFFER_0:
+        return 0
+    case STREAMING_BUFFER_100000:
+        return 1
+    case STREAMING_BUFFER_1000000:
+        return 2
+    }
+    return 3
+}
+
+func streaming_size(code byte) uint {
+    switch code {
+    case 0:
+        return STREAMING_BUFFER_0
+    case 1:
+        return STREAMING_BUFFER_100000
+    case 2:
+        return STREAMING_BUFFER_1000000
+    }
+    return STREAMING_BUFFER_OTHER
+}
@@ -0,0 +1,80 @@
+package quicklz
+
+// Compress src of any size, splitting it into as many blocks as needed
+//
+// A single block cannot hold more than MaxBlockSize bytes. The result is the
+// concatenation of the blocks, which DecompressLarge turns back into src.
+func (q *Compressor) CompressLarge(src []byte) ([]byte, error) {
+    return q.compress_large(src, q.large_block_size())
+}
+
+// Decompress a sequence of blocks produced by CompressLarge
+func (q *Decompressor) DecompressLarge(src []byte) ([]byte, error) {
+    total := 0
+    for rest := src; len(rest) > 0; {
+        h, err := ParseHeader(rest)
+        if err != nil {
+            return nil, err
+        }
+        if len(rest) < h.CompressedSize {
+            return nil, ErrTruncated
+        }
+        total += h.DecompressedSize
+        rest = rest[h.CompressedSize:]
+    }
+
+    dst := make([]byte, 0, total)
+    for len(src) > 0 {
+        c, err := SizeCompressed(src)
+        if err != nil {
+            return nil, err
+        }
+        dst, err = q.AppendDecompress(dst, src[:c])
+        if err != nil {
+            return nil, err
+        }
+        src = src[c:]
+    }
+    return dst, nil
+}
+
+// Compress src of any size, splitting it into as many blocks as needed
+func (q *Qlz) CompressLarge(src []byte) ([]byte, error) {
+    return q.get_compressor().CompressLarge(src)
+}
+
+// Decompress a sequence of blocks produced by CompressLarge
+func (q *Qlz) DecompressLarge(src []byte) ([]byte, error) {
+    return q.get_decompressor().DecompressLarge(src)
+}
+
+// Without a streaming buffer every block stands alone, so the blocks are as
+// large as possible; otherwise they are sized like the blocks of a Writer to
+// keep the history.
+func (q *Compressor) large_block_size() int {
+    if q._STREAMING_BUFFER == 0 {
+        block := int64(MaxBlockSize)
+        if max_int := int64(^uint(0) >> 1); block > max_int {
+            block = max_int
+        }
+        return int(block)
+    }
+    return writer_chunk(q._STREAMING_BUFFER)
+}
+
+func (q *Compressor) compress_large(src []byte, block int) ([]byte, error) {
+    dst := make([]byte, 0, StreamCompressBound(len(src), block))
+    for len(src) > 0 {
+        n := len(src)
+        if n > block {
+            n = block
+        }
+        var err error
+        dst, err = q.AppendCompress(dst, src[:n])
+        if err != nil {
+            return nil, err
+        }
+        src = src[n:]
+    }
+    return dst, nil
+}
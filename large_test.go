@@ -0,0 +1,116 @@
+package quicklz
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "testing"
+)
+
+// Count the blocks of the output of CompressLarge
+func count_blocks(t *testing.T, src []byte) int {
+    n := 0
+    for len(src) > 0 {
+        c, err := SizeCompressed(src)
+        if err != nil {
+            t.Fatal(err)
+        }
+        src = src[c:]
+        n++
+    }
+    return n
+}
+
+func TestCompressLargeBlocks(t *testing.T) {
+    src := test_data(25500)
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        for _, buf := range []uint{STREAMING_BUFFER_0, STREAMING_BUFFER_100000} {
+            c, _ := NewCompressor(level, buf)
+            d, _ := NewDecompressor(level, buf)
+            for _, n := range []int{0, 1, 999, 1000, 1001, len(src)} {
+                out, err := c.compress_large(src[:n], 1000)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if blocks := count_blocks(t, out); blocks != (n + 999) / 1000 {
+                    t.Fatalf("level %d, buffer %d, %d bytes: %d blocks", level, buf, n, blocks)
+                }
+                data, err := d.DecompressLarge(out)
+                if err != nil || !bytes.Equal(data, src[:n]) {
+                    t.Fatalf("level %d, buffer %d, %d bytes: %v", level, buf, n, err)
+                }
+            }
+        }
+    }
+}
+
+func TestCompressLargeStreaming(t *testing.T) {
+    // Blocks are sized for the streaming buffer
+    src := test_data(300000)
+    q, _ := New(COMPRESSION_LEVEL_2, STREAMING_BUFFER_1000000)
+    out, err := q.CompressLarge(src)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if blocks := count_blocks(t, out); blocks != (len(src) + _WRITER_CHUNK - 1) / _WRITER_CHUNK {
+        t.Fatalf("%d blocks", blocks)
+    }
+    data, err := q.DecompressLarge(out)
+    if err != nil || !bytes.Equal(data, src) {
+        t.Fatal(err)
+    }
+}
+
+func TestDecompressLargeErrors(t *testing.T) {
+    src := test_data(5000)
+    c, _ := NewCompressor(COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+    out, _ := c.compress_large(src, 1000)
+    second, _ := SizeCompressed(out)
+
+    bad_size := append([]byte(nil), out...)
+    binary.LittleEndian.PutUint32(bad_size[second+1:], 5)
+
+    tests := []struct {
+        name string
+        src []byte
+        err error
+    }{
+        {"truncated last block", out[:len(out) - 1], ErrTruncated},
+        {"truncated header", out[:second + 4], ErrTruncated},
+        {"corrupt block size", bad_size, ErrCorrupt},
+    }
+    for _, tt := range tests {
+        d, _ := NewDecompressor(COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+        data, err := d.DecompressLarge(tt.src)
+        if !errors.Is(err, tt.err) || data != nil {
+            t.Errorf("%s: got %d bytes with %v, want %v", tt.name, len(data), err, tt.err)
+        }
+    }
+}
+
+func TestDecompressLargeCorruptBlock(t *testing.T) {
+    src := test_data(5000)
+    c, _ := NewCompressor(COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+    out, _ := c.compress_large(src, 1000)
+    start, _ := SizeCompressed(out)
+    n, _ := SizeCompressed(out[start:])
+    end := start + n
+
+    corrupt := 0
+    for i := start + 9; i < end; i++ {
+        bad := append([]byte(nil), out...)
+        bad[i] ^= 0xff
+        d, _ := NewDecompressor(COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+        data, err := d.DecompressLarge(bad)
+        if !errors.Is(err, ErrCorrupt) {
+            continue
+        }
+        corrupt++
+        if data != nil {
+            t.Fatalf("byte %d: %d bytes returned with %v", i, len(data), err)
+        }
+    }
+    if corrupt == 0 {
+        t.Fatal("no corruption was detected")
+    }
+}
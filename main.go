@@ -14,9 +14,9 @@
 package quicklz
 
 import (
+    "context"
     "encoding/binary"
-    "errors"
-    "strconv"
+    "fmt"
 )
 
 const _VERSION_MAJOR = 1
@@ -28,6 +28,12 @@ const _UNCONDITIONAL_MATCHLEN = 6
 const _UNCOMPRESSED_END = 4
 const _CWORD_LEN = 4
 
+// Control words between two checks for cancellation in the core loops
+const _CANCEL_CHECK_INTERVAL = 1024
+
+// Smallest allocation of a growing decompression stream buffer
+const _STREAM_BUFFER_CHUNK = 65536
+
 const (
     COMPRESSION_LEVEL_1 = 1
     COMPRESSION_LEVEL_2 = 2
@@ -40,14 +46,39 @@ const (
     STREAMING_BUFFER_1000000 = 1000000
 )
 
+// Qlz combines a Compressor and a Decompressor sharing one configuration,
+// the state of each side is allocated on first use
+//
+// A Qlz is not safe for concurrent use, wrap it with Locked or use a Pool
+// when it is shared between goroutines.
 type Qlz struct {
+    config
+    compressor *Compressor
+    decompressor *Decompressor
+}
+
+// Compressor holds the compression state only
+type Compressor struct {
+    config
+    state *state_compress
+    primed *state_compress  // state after the dictionary, restored after each block
+    primed_slots []int      // hash slots holding dictionary offsets
+}
+
+// Decompressor holds the decompression state only
+type Decompressor struct {
+    config
+    state *state_decompress
+    primed *state_decompress
+    primed_slots []int
+}
+
+type config struct {
     offset_base int64
     _POINTERS uint
     _HASH_VALUES uint
     _STREAMING_BUFFER uint
     _COMPRESSION_LEVEL uint
-    state *state_compress
-    state2 *state_decompress
 }
 
 type hash_compress struct {
@@ -77,7 +108,30 @@ type state_decompress struct {
 
 // Create new compressor/decompressor
 func New(compression_level uint, streaming_buffer uint) (*Qlz, error) {
-    q := Qlz{}
+    cfg, err := new_config(compression_level, streaming_buffer)
+    return &Qlz{config: cfg}, err
+}
+
+// Create new compressor
+func NewCompressor(compression_level uint, streaming_buffer uint) (*Compressor, error) {
+    cfg, err := new_config(compression_level, streaming_buffer)
+    if err != nil {
+        return nil, err
+    }
+    return cfg.new_compressor(), nil
+}
+
+// Create new decompressor
+func NewDecompressor(compression_level uint, streaming_buffer uint) (*Decompressor, error) {
+    cfg, err := new_config(compression_level, streaming_buffer)
+    if err != nil {
+        return nil, err
+    }
+    return cfg.new_decompressor(), nil
+}
+
+func new_config(compression_level uint, streaming_buffer uint) (config, error) {
+    q := config{}
     switch compression_level {
     case COMPRESSION_LEVEL_1:
         q._COMPRESSION_LEVEL = compression_level
@@ -92,77 +146,239 @@ func New(compression_level uint, streaming_buffer uint) (*Qlz, error) {
         q._POINTERS = 16
         q._HASH_VALUES = 4096
     default:
-        return &q, errors.New("invalid compression level (" + strconv.Itoa(int(compression_level)) + ")")
+        return q, fmt.Errorf("%w (%d)", ErrInvalidLevel, compression_level)
     }
 
-    if streaming_buffer == STREAMING_BUFFER_0 ||
-        streaming_buffer == STREAMING_BUFFER_100000 ||
-        streaming_buffer == STREAMING_BUFFER_1000000 {
-            q._STREAMING_BUFFER = streaming_buffer
-    } else {
-        return &q, errors.New("invalid streaming buffer size (" + strconv.Itoa(int(streaming_buffer)) + ")")
+    // Any size up to MaxBlockSize is accepted; sizes other than the
+    // predefined ones are all recorded as code 3 in the header, so both sides
+    // must agree on the size out of band.
+    if streaming_buffer == STREAMING_BUFFER_OTHER || uint64(streaming_buffer) > MaxBlockSize {
+        return q, fmt.Errorf("%w (%d)", ErrInvalidStreamingBuffer, streaming_buffer)
     }
-    q.state = q.new_compress_state()
-    q.state2 = q.new_decompress_state()
+    q._STREAMING_BUFFER = streaming_buffer
 
     if compression_level == COMPRESSION_LEVEL_1 && streaming_buffer == STREAMING_BUFFER_0 {
         q.offset_base = 0
     } else {
         q.offset_base = -1
     }
+    return q, nil
+}
 
-    q.reset_table_compress()
-    q.reset_table_decompress()
+func (q *config) new_compressor() *Compressor {
+    c := Compressor{config: *q}
+    c.state = q.new_compress_state(nil)
+    c.reset_table_compress()
+    return &c
+}
 
-    return &q, nil
+func (q *config) new_decompressor() *Decompressor {
+    d := Decompressor{config: *q}
+    d.state = q.new_decompress_state(nil)
+    d.reset_table_decompress()
+    return &d
 }
 
-func (q *Qlz) new_compress_state() *state_compress {
-    state := state_compress{}
-    if q._STREAMING_BUFFER > 0 {
-        state.stream_buffer = make([]byte, q._STREAMING_BUFFER)
+// Allocate the compression state, reusing the buffers of state when they are
+// large enough
+func (q *config) new_compress_state(state *state_compress) *state_compress {
+    if state == nil {
+        state = &state_compress{}
     }
+    state.stream_buffer = reuse_bytes(state.stream_buffer, q._STREAMING_BUFFER)
     state.stream_counter = 0
-    state.hash = make([]hash_compress, q._HASH_VALUES)
+    if uint(cap(state.hash)) >= q._HASH_VALUES {
+        state.hash = state.hash[:q._HASH_VALUES]
+    } else {
+        state.hash = make([]hash_compress, q._HASH_VALUES)
+    }
     if q._COMPRESSION_LEVEL != 1 {
         for i := uint(0); i < q._HASH_VALUES; i++ {
-            state.hash[i].offset2 = make([]int64, q._POINTERS)
+            state.hash[i].offset2 = reuse_offsets(state.hash[i].offset2, q._POINTERS)
         }
     }
-    state.hash_counter = make([]byte, q._HASH_VALUES)
+    state.hash_counter = reuse_bytes(state.hash_counter, q._HASH_VALUES)
 
-    return &state
+    return state
 }
 
-func (q *Qlz) new_decompress_state() *state_decompress {
-    state := state_decompress{}
+// Allocate the decompression state, reusing the buffers of state when they
+// are large enough
+//
+// The stream buffer grows with the history instead, the streaming buffer
+// comes from the header of a file and is not worth allocating before the
+// data arrives.
+func (q *config) new_decompress_state(state *state_decompress) *state_decompress {
+    if state == nil {
+        state = &state_decompress{}
+    }
+    state.stream_buffer = state.stream_buffer[:0]
     if q._COMPRESSION_LEVEL == 1 || q._COMPRESSION_LEVEL == 2 {
-        if q._STREAMING_BUFFER > 0 {
-            state.stream_buffer = make([]byte, q._STREAMING_BUFFER)
+        if uint(cap(state.hash)) >= q._HASH_VALUES {
+            state.hash = state.hash[:q._HASH_VALUES]
+        } else {
+            state.hash = make([]hash_decompress, q._HASH_VALUES)
         }
-        state.hash = make([]hash_decompress, q._HASH_VALUES)
         for i := uint(0); i < q._HASH_VALUES; i++ {
-            state.hash[i].offset2 = make([]int64, q._POINTERS)
-        }
-        state.hash_counter = make([]byte, q._HASH_VALUES)
-    } else if q._COMPRESSION_LEVEL == 3 {
-        if q._STREAMING_BUFFER > 0 {
-            state.stream_buffer = make([]byte, q._STREAMING_BUFFER)
-        }
-        if q._COMPRESSION_LEVEL <= 2 {
-            state.hash = make([]hash_decompress, q._HASH_VALUES)
-            for i := uint(0); i < q._HASH_VALUES; i++ {
-                state.hash[i].offset2 = make([]int64, q._POINTERS)
-            }
+            state.hash[i].offset2 = reuse_offsets(state.hash[i].offset2, q._POINTERS)
         }
-
+        state.hash_counter = reuse_bytes(state.hash_counter, q._HASH_VALUES)
     }
     state.stream_counter = 0
 
-    return &state
+    return state
+}
+
+// Make the stream buffer hold at least n bytes of history, growing it
+// geometrically up to the streaming buffer
+func (q *Decompressor) grow_stream_buffer(n int64) {
+    s := q.state
+    if int64(len(s.stream_buffer)) >= n {
+        return
+    }
+    if int64(cap(s.stream_buffer)) >= n {
+        s.stream_buffer = s.stream_buffer[:n]
+        return
+    }
+    c := 2 * int64(cap(s.stream_buffer))
+    if c < _STREAM_BUFFER_CHUNK {
+        c = _STREAM_BUFFER_CHUNK
+    }
+    if c > int64(q._STREAMING_BUFFER) {
+        c = int64(q._STREAMING_BUFFER)
+    }
+    if c < n {
+        c = n
+    }
+    b := make([]byte, n, c)
+    copy(b, s.stream_buffer)
+    s.stream_buffer = b
+}
+
+func reuse_bytes(b []byte, n uint) []byte {
+    if uint(cap(b)) >= n {
+        return b[:n]
+    }
+    return make([]byte, n)
+}
+
+func reuse_offsets(o []int64, n uint) []int64 {
+    if uint(cap(o)) >= n {
+        return o[:n]
+    }
+    return make([]int64, n)
+}
+
+func (q *Qlz) get_compressor() *Compressor {
+    if q.compressor == nil {
+        q.compressor = q.new_compressor()
+    }
+    return q.compressor
+}
+
+func (q *Qlz) get_decompressor() *Decompressor {
+    if q.decompressor == nil {
+        q.decompressor = q.new_decompressor()
+    }
+    return q.decompressor
+}
+
+// Reset the compression and decompression history
+func (q *Qlz) Reset() {
+    q.ResetCompressor()
+    q.ResetDecompressor()
+}
+
+// Reset the compression history, the next block starts a new stream
+func (q *Qlz) ResetCompressor() {
+    if q.compressor != nil {
+        q.compressor.Reset()
+    }
+}
+
+// Reset the decompression history, the next block starts a new stream
+func (q *Qlz) ResetDecompressor() {
+    if q.decompressor != nil {
+        q.decompressor.Reset()
+    }
+}
+
+// Change the compression level and streaming buffer, reusing the allocated
+// state where the sizes allow; the history of both sides is reset
+func (q *Qlz) Reconfigure(compression_level uint, streaming_buffer uint) error {
+    cfg, err := new_config(compression_level, streaming_buffer)
+    if err != nil {
+        return err
+    }
+    q.config = cfg
+    if q.compressor != nil {
+        q.compressor.reconfigure(cfg)
+    }
+    if q.decompressor != nil {
+        q.decompressor.reconfigure(cfg)
+    }
+    return nil
+}
+
+// Reset the compression history, the next block starts a new stream
+func (q *Compressor) Reset() {
+    if q.primed != nil {
+        q.restore_primed()
+        return
+    }
+    q.state.stream_counter = 0
+    q.reset_table_compress()
+}
+
+// Change the compression level and streaming buffer, reusing the allocated
+// state where the sizes allow; the history is reset
+func (q *Compressor) Reconfigure(compression_level uint, streaming_buffer uint) error {
+    cfg, err := new_config(compression_level, streaming_buffer)
+    if err != nil {
+        return err
+    }
+    q.reconfigure(cfg)
+    return nil
+}
+
+func (q *Compressor) reconfigure(cfg config) {
+    q.config = cfg
+    q.primed = nil
+    q.primed_slots = nil
+    q.state = cfg.new_compress_state(q.state)
+    q.reset_table_compress()
+}
+
+// Reset the decompression history, the next block starts a new stream
+func (q *Decompressor) Reset() {
+    if q.primed != nil {
+        q.restore_primed()
+        return
+    }
+    q.state.stream_counter = 0
+    q.reset_table_decompress()
+}
+
+// Change the compression level and streaming buffer, reusing the allocated
+// state where the sizes allow; the history is reset
+func (q *Decompressor) Reconfigure(compression_level uint, streaming_buffer uint) error {
+    cfg, err := new_config(compression_level, streaming_buffer)
+    if err != nil {
+        return err
+    }
+    q.reconfigure(cfg)
+    return nil
+}
+
+func (q *Decompressor) reconfigure(cfg config) {
+    q.config = cfg
+    q.primed = nil
+    q.primed_slots = nil
+    q.state = cfg.new_decompress_state(q.state)
+    q.reset_table_decompress()
 }
 
-func (q *Qlz) reset_table_compress() {
+func (q *Compressor) reset_table_compress() {
     for i := uint(0); i < q._HASH_VALUES; i++ {
         if q._COMPRESSION_LEVEL == 1 {
             q.state.hash[i].offset = q.offset_base
@@ -172,29 +388,90 @@ func (q *Qlz) reset_table_compress() {
     }
 }
 
-func (q *Qlz) reset_table_decompress() {
+func (q *Decompressor) reset_table_decompress() {
     if q._COMPRESSION_LEVEL == 2 {
         for i := uint(0); i < q._HASH_VALUES; i++ {
-            q.state2.hash_counter[i] = 0
+            q.state.hash_counter[i] = 0
         }
     }
 }
 
 // Compress the data in source to destination and return the compressed data length
 func (q *Qlz) Compress(source, destination *[]byte) (int64, error) {
-    if len(*source) == 0 || len(*destination) == 0 {
-        return 0, errors.New("zero length buffer")
+    return q.get_compressor().Compress(source, destination)
+}
+
+// Decompress the data in source to destination and return the decompressed data length
+func (q *Qlz) Decompress(source, destination *[]byte) (int64, error) {
+    return q.get_decompressor().Decompress(source, destination)
+}
+
+// Decompress like Decompress, verifying the size and returning the number
+// of bytes that follow the block in source
+func (q *Qlz) DecompressStrict(source, destination *[]byte) (int64, int64, error) {
+    return q.get_decompressor().DecompressStrict(source, destination)
+}
+
+// Decompress the first block in src strictly and append the data to dst
+func (q *Qlz) AppendDecompressStrict(dst, src []byte) ([]byte, int, error) {
+    return q.get_decompressor().AppendDecompressStrict(dst, src)
+}
+
+// Compress like Compress, giving up with ctx.Err() once ctx is done
+func (q *Qlz) CompressContext(ctx context.Context, source, destination *[]byte) (int64, error) {
+    return q.get_compressor().CompressContext(ctx, source, destination)
+}
+
+// Decompress like Decompress, giving up with ctx.Err() once ctx is done
+func (q *Qlz) DecompressContext(ctx context.Context, source, destination *[]byte) (int64, error) {
+    return q.get_decompressor().DecompressContext(ctx, source, destination)
+}
+
+// Compress src and append the compressed block to dst, growing it as needed
+func (q *Qlz) AppendCompress(dst, src []byte) ([]byte, error) {
+    return q.get_compressor().AppendCompress(dst, src)
+}
+
+// Decompress the block in src and append the data to dst, growing it as needed
+func (q *Qlz) AppendDecompress(dst, src []byte) ([]byte, error) {
+    return q.get_decompressor().AppendDecompress(dst, src)
+}
+
+// Compress the data in source to destination and return the compressed data length
+//
+// An empty source is stored as a 3 byte block without touching the history.
+func (q *Compressor) Compress(source, destination *[]byte) (int64, error) {
+    return q.compress(context.Background(), source, destination)
+}
+
+// Compress like Compress, giving up with ctx.Err() once ctx is done
+//
+// The compression history is reset when that happens.
+func (q *Compressor) CompressContext(ctx context.Context, source, destination *[]byte) (int64, error) {
+    r, err := q.compress(ctx, source, destination)
+    if err != nil && err == ctx.Err() {
+        q.Reset()
+    }
+    return r, err
+}
+
+func (q *Compressor) compress(ctx context.Context, source, destination *[]byte) (int64, error) {
+    if err := ctx.Err(); err != nil {
+        return 0, err
     }
-    if len(*destination) < len(*source) + 400 {
-        return 0, errors.New("destination buffer is smaller than the size of the source buffer + 400")
+    if q.primed != nil {
+        defer q.restore_primed()
     }
     var r int64
     var compressed uint32
     var base int64
     size := int64(len(*source))
 
-    if size == 0 || size > 0xffffffff - 400 {
-        return 0, nil
+    if size > MaxBlockSize {
+        return 0, ErrInputTooLarge
+    }
+    if len(*destination) < CompressBound(len(*source)) {
+        return 0, fmt.Errorf("%w: destination buffer is smaller than CompressBound(len(source))", ErrShortBuffer)
     }
 
     if size < 216 {
@@ -203,9 +480,16 @@ func (q *Qlz) Compress(source, destination *[]byte) (int64, error) {
         base = 9
     }
 
-    if q._STREAMING_BUFFER <= 0 || (q._STREAMING_BUFFER > 0 && q.state.stream_counter + size - 1 >= int64(q._STREAMING_BUFFER)) {
+    if size == 0 {
+        r = base
+        compressed = 0
+    } else if q._STREAMING_BUFFER <= 0 || (q._STREAMING_BUFFER > 0 && q.state.stream_counter + size - 1 >= int64(q._STREAMING_BUFFER)) {
         q.reset_table_compress()
-        r = base + q.compress_core(source, 0, destination, base, size)
+        n, err := q.compress_core(ctx, source, 0, destination, base, size)
+        if err != nil {
+            return 0, err
+        }
+        r = base + n
         if q._STREAMING_BUFFER > 0 {
             q.reset_table_compress()
         }
@@ -220,7 +504,11 @@ func (q *Qlz) Compress(source, destination *[]byte) (int64, error) {
     } else if q._STREAMING_BUFFER > 0 && !(q.state.stream_counter + size - 1 >= int64(q._STREAMING_BUFFER)) {
         src_index := q.state.stream_counter
         copy(q.state.stream_buffer[src_index:], (*source)[:size])
-        r = base + q.compress_core(&q.state.stream_buffer, src_index, destination, base, size)
+        n, err := q.compress_core(ctx, &q.state.stream_buffer, src_index, destination, base, size)
+        if err != nil {
+            return 0, err
+        }
+        r = base + n
 
         if r == base {
             copy((*destination)[base:], q.state.stream_buffer[src_index:src_index + size])
@@ -244,56 +532,185 @@ func (q *Qlz) Compress(source, destination *[]byte) (int64, error) {
 
     (*destination)[0] |= byte(q._COMPRESSION_LEVEL << 2)
     (*destination)[0] |= 1 << 6
-    if q._STREAMING_BUFFER == 0 {
-        (*destination)[0] |= 0 << 4
-    } else if q._STREAMING_BUFFER == 100000 {
-        (*destination)[0] |= 1 << 4
-    } else if q._STREAMING_BUFFER == 1000000 {
-        (*destination)[0] |= 2 << 4
-    } else {
-        (*destination)[0] |= 3 << 4
-    }
+    (*destination)[0] |= streaming_code(q._STREAMING_BUFFER) << 4
 
     return r, nil
 }
 
 // Decompress the data in source to destination and return the decompressed data length
-func (q *Qlz) Decompress(source, destination *[]byte) (int64, error) {
-    if len(*source) == 0 || len(*destination) == 0 {
-        return 0, errors.New("zero length buffer")
+func (q *Decompressor) Decompress(source, destination *[]byte) (int64, error) {
+    return q.decompress(context.Background(), source, destination)
+}
+
+// Decompress like Decompress, giving up with ctx.Err() once ctx is done
+//
+// The decompression history is reset when that happens.
+func (q *Decompressor) DecompressContext(ctx context.Context, source, destination *[]byte) (int64, error) {
+    r, err := q.decompress(ctx, source, destination)
+    if err != nil && err == ctx.Err() {
+        q.Reset()
+    }
+    return r, err
+}
+
+func (q *Decompressor) decompress(ctx context.Context, source, destination *[]byte) (int64, error) {
+    if err := ctx.Err(); err != nil {
+        return 0, err
+    }
+    if q.primed != nil {
+        defer q.restore_primed()
+    }
+    h, err := ParseHeader(*source)
+    if err != nil {
+        return 0, err
+    }
+    if len(*source) < h.CompressedSize {
+        return 0, ErrTruncated
+    }
+    if h.Level != q._COMPRESSION_LEVEL {
+        return 0, ErrLevelMismatch
+    }
+    if streaming_code(h.StreamingBuffer) != streaming_code(q._STREAMING_BUFFER) {
+        return 0, ErrStreamingMismatch
     }
-    dsiz := Size_decompressed(source)
+    dsiz := int64(h.DecompressedSize)
     if int64(len(*destination)) < dsiz {
-        return 0, errors.New("destination buffer size is smaller than source buffer")
+        return 0, fmt.Errorf("%w: destination buffer size is smaller than the decompressed size", ErrShortBuffer)
+    }
+    // Empty blocks are not part of the history
+    if dsiz == 0 {
+        return 0, nil
     }
 
     if q._STREAMING_BUFFER <= 0 ||
-       (q._STREAMING_BUFFER > 0 && q.state2.stream_counter + Size_decompressed(source) - 1 >= int64(q._STREAMING_BUFFER)) {
+       (q._STREAMING_BUFFER > 0 && q.state.stream_counter + dsiz - 1 >= int64(q._STREAMING_BUFFER)) {
         if ((*source)[0] & 1) == 1 {
             q.reset_table_decompress()
-            dsiz = q.decompress_core(source, 0, destination, 0, dsiz, 0)
+            dsiz, err = q.decompress_core(ctx, source, 0, destination, 0, dsiz, 0)
+            if err != nil {
+                return 0, err
+            }
         } else {
             header_size := size_header(source)
             copy(*destination, (*source)[header_size:header_size+dsiz])
         }
-        q.state2.stream_counter = 0
+        q.state.stream_counter = 0
         q.reset_table_decompress()
     } else if q._STREAMING_BUFFER > 0 {
-        dst_index := q.state2.stream_counter
+        dst_index := q.state.stream_counter
+        q.grow_stream_buffer(dst_index + dsiz)
         if ((*source)[0] & 1) == 1 {
-            dsiz = q.decompress_core(source, 0, &q.state2.stream_buffer, dst_index, dsiz, 0)
+            dsiz, err = q.decompress_core(ctx, source, 0, &q.state.stream_buffer, dst_index, dsiz, 0)
+            if err != nil {
+                return 0, err
+            }
         } else {
             header_size := size_header(source)
-            copy(q.state2.stream_buffer[dst_index:], (*source)[header_size:header_size+dsiz])
+            copy(q.state.stream_buffer[dst_index:], (*source)[header_size:header_size+dsiz])
             q.reset_table_decompress()
         }
-        copy(*destination, q.state2.stream_buffer[dst_index:dst_index+dsiz])
-        q.state2.stream_counter += dsiz
+        copy(*destination, q.state.stream_buffer[dst_index:dst_index+dsiz])
+        q.state.stream_counter += dsiz
     }
     return dsiz, nil
 }
 
-func (q *Qlz) compress_core(source *[]byte, src_index int64, destination *[]byte, dst_index int64, size int64) int64 {
+// Compress src and append the compressed block to dst, growing it as needed
+func (q *Compressor) AppendCompress(dst, src []byte) ([]byte, error) {
+    n := len(dst)
+    bound := CompressBound(len(src))
+    dst = grow_slice(dst, bound)
+    destination := dst[n:n + bound]
+    r, err := q.Compress(&src, &destination)
+    if err != nil {
+        return dst[:n], err
+    }
+    return dst[:n + int(r)], nil
+}
+
+// Decompress the block in src and append the data to dst, growing it as needed
+func (q *Decompressor) AppendDecompress(dst, src []byte) ([]byte, error) {
+    dsiz, err := decompressed_size(src)
+    if err != nil {
+        return dst, err
+    }
+    n := len(dst)
+    dst = grow_slice(dst, dsiz)
+    destination := dst[n:n + dsiz]
+    r, err := q.Decompress(&src, &destination)
+    if err != nil {
+        return dst[:n], err
+    }
+    return dst[:n + int(r)], nil
+}
+
+// Decompress like Decompress, additionally verifying that the output has the
+// size declared in the header, and return the number of bytes that follow
+// the block in source
+//
+// Extra bytes are not an error, the next block of a concatenation starts
+// where the returned count begins.
+func (q *Decompressor) DecompressStrict(source, destination *[]byte) (int64, int64, error) {
+    h, err := ParseHeader(*source)
+    if err != nil {
+        return 0, 0, err
+    }
+    if len(*source) < h.CompressedSize {
+        return 0, 0, ErrTruncated
+    }
+    block := (*source)[:h.CompressedSize]
+    r, err := q.Decompress(&block, destination)
+    if err != nil {
+        return 0, 0, err
+    }
+    if r != int64(h.DecompressedSize) {
+        return 0, 0, &CorruptError{int64(h.HeaderLen), "decompressed size does not match the header"}
+    }
+    return r, int64(len(*source) - h.CompressedSize), nil
+}
+
+// Decompress the first block in src strictly and append the data to dst,
+// returning the number of bytes that follow the block in src
+func (q *Decompressor) AppendDecompressStrict(dst, src []byte) ([]byte, int, error) {
+    dsiz, err := decompressed_size(src)
+    if err != nil {
+        return dst, 0, err
+    }
+    n := len(dst)
+    dst = grow_slice(dst, dsiz)
+    destination := dst[n:n + dsiz]
+    r, trailing, err := q.DecompressStrict(&src, &destination)
+    if err != nil {
+        return dst[:n], 0, err
+    }
+    return dst[:n + int(r)], int(trailing), nil
+}
+
+// Decompressed size of the block in src, checking that the whole block is
+// there before anything is allocated for it
+func decompressed_size(src []byte) (int, error) {
+    h, err := ParseHeader(src)
+    if err != nil {
+        return 0, err
+    }
+    if len(src) < h.CompressedSize {
+        return 0, ErrTruncated
+    }
+    return h.DecompressedSize, nil
+}
+
+func grow_slice(b []byte, n int) []byte {
+    if cap(b) - len(b) >= n {
+        return b
+    }
+    nb := make([]byte, len(b), len(b) + n)
+    copy(nb, b)
+    return nb
+}
+
+func (q *Compressor) compress_core(ctx context.Context, source *[]byte, src_index int64, destination *[]byte, dst_index int64, size int64) (int64, error) {
+    done := ctx.Done()
+    cwords := 0
     last_byte := size - 1
     src := int64(0)
     cword_ptr := int64(0)
@@ -310,7 +727,7 @@ func (q *Qlz) compress_core(source *[]byte, src_index int64, destination *[]byte
     for src <= last_matchstart {
         if (cword_val & 1) == 1 {
             if src > (size >> 1) && dst > src - (src >> 5) {
-                return 0
+                return 0, nil
             }
 
             fast_write(cword_val >> 1 | (uint32(1) << 31), destination, cword_ptr + dst_index, _CWORD_LEN)
@@ -318,6 +735,18 @@ func (q *Qlz) compress_core(source *[]byte, src_index int64, destination *[]byte
             cword_ptr = dst
             dst += _CWORD_LEN
             cword_val = uint32(1) << 31
+
+            if done != nil {
+                cwords++
+                if cwords == _CANCEL_CHECK_INTERVAL {
+                    cwords = 0
+                    select {
+                    case <-done:
+                        return 0, ctx.Err()
+                    default:
+                    }
+                }
+            }
         }
         if q._COMPRESSION_LEVEL == 1 {
             var o int64 = 0
@@ -539,13 +968,15 @@ func (q *Qlz) compress_core(source *[]byte, src_index int64, destination *[]byte
     fast_write((cword_val >> 1) | (uint32(1) << 31), destination, cword_ptr + dst_index, _CWORD_LEN)
 
     if dst < 9 {
-        return 9
+        return 9, nil
     } else {
-        return dst
+        return dst, nil
     }
 }
 
-func (q *Qlz) decompress_core(source *[]byte, src_index int64, destination *[]byte, dst_index int64, size int64, history int64) int64 {
+func (q *Decompressor) decompress_core(ctx context.Context, source *[]byte, src_index int64, destination *[]byte, dst_index int64, size int64, history int64) (int64, error) {
+    done := ctx.Done()
+    cwords := 0
     src := size_header(source)
     dst := dst_index
     last_destination_byte := dst + size - 1
@@ -560,14 +991,26 @@ func (q *Qlz) decompress_core(source *[]byte, src_index int64, destination *[]by
 
         if cword_val == 1 {
             if src + _CWORD_LEN - 1 > last_source_byte {
-                return 0
+                return 0, &CorruptError{src, "unexpected end of block"}
             }
             cword_val = fast_read(source, src, _CWORD_LEN)
             src += _CWORD_LEN
+
+            if done != nil {
+                cwords++
+                if cwords == _CANCEL_CHECK_INTERVAL {
+                    cwords = 0
+                    select {
+                    case <-done:
+                        return 0, ctx.Err()
+                    default:
+                    }
+                }
+            }
         }
 
         if src + 4 - 1 > last_source_byte {
-            return 0
+            return 0, &CorruptError{src, "unexpected end of block"}
         }
 
         fetch = fast_read(source, src, 4)
@@ -580,7 +1023,7 @@ func (q *Qlz) decompress_core(source *[]byte, src_index int64, destination *[]by
                 var hash uint32
                 cword_val = cword_val >> 1
                 hash = (fetch >> 4) & 0xfff
-                offset2 = q.state2.hash[hash].offset
+                offset2 = q.state.hash[hash].offset
 
                 if (fetch & 0xf) != 0 {
                     matchlen = (fetch & 0xf) + 2
@@ -595,7 +1038,7 @@ func (q *Qlz) decompress_core(source *[]byte, src_index int64, destination *[]by
                 cword_val = cword_val >> 1
                 hash = (fetch >> 5) & 0x7ff
                 c = byte(fetch & 0x3)
-                offset2 = q.state2.hash[hash].offset2[c]
+                offset2 = q.state.hash[hash].offset2[c]
 
                 if (fetch & 28) != 0 {
                     matchlen = ((fetch >> 2) & 0x7) + 2
@@ -634,11 +1077,11 @@ func (q *Qlz) decompress_core(source *[]byte, src_index int64, destination *[]by
             }
 
             if offset2 < history || offset2 > dst - _MINOFFSET - 1 {
-                return 0
+                return 0, &CorruptError{src, "match offset out of range"}
             }
 
             if int64(matchlen) > last_destination_byte - dst - _UNCOMPRESSED_END + 1 {
-                return 0
+                return 0, &CorruptError{src, "match runs past the decompressed size"}
             }
 
             memcpy_up(destination, dst, destination, offset2, int64(matchlen))
@@ -668,7 +1111,7 @@ func (q *Qlz) decompress_core(source *[]byte, src_index int64, destination *[]by
                         cword_val = 1 << 31
                     }
                     if src >= last_source_byte + 1 {
-                        return 0
+                        return 0, &CorruptError{src, "unexpected end of block"}
                     }
 
                     (*destination)[dst] = (*source)[src]
@@ -679,7 +1122,7 @@ func (q *Qlz) decompress_core(source *[]byte, src_index int64, destination *[]by
                 if q._COMPRESSION_LEVEL <= 2 {
                     q.update_hash_upto(destination, &last_hashed, last_destination_byte - 3)
                 }
-                return size
+                return size, nil
             }
         }
     }
@@ -722,6 +1165,9 @@ func Size_decompressed(source *[]byte) int64 {
     } else {
         n = 1
     }
+    if n == 1 {
+        return int64((*source)[2])
+    }
     r = int64(fast_read(source, int64(1 + n), n))
     r = r & (0xffffffff >> ((4 - n)*8))
     return r
@@ -736,11 +1182,26 @@ func Size_compressed(source *[]byte) int64 {
     } else {
         n = 1
     }
+    if n == 1 {
+        return int64((*source)[1])
+    }
     r = int64(fast_read(source, 1, n))
     r = r & (0xffffffff >> ((4 - n)*8))
     return r
 }
 
+// Get the size of the data in the block at the start of src after decompression
+func SizeDecompressed(src []byte) (int, error) {
+    h, err := ParseHeader(src)
+    return h.DecompressedSize, err
+}
+
+// Get the size of the compressed block at the start of src, header included
+func SizeCompressed(src []byte) (int, error) {
+    h, err := ParseHeader(src)
+    return h.CompressedSize, err
+}
+
 func size_header(source *[]byte) int64 {
     if ((*source)[0] & 2) == 2 {
         return 2 * 4 + 1
@@ -763,31 +1224,31 @@ func memcpy_up(destination *[]byte, dst_index int64, source *[]byte, src_index i
     }
 }
 
-func (q *Qlz) update_hash(source *[]byte, s int64) {
+func (q *Decompressor) update_hash(source *[]byte, s int64) {
     if q._COMPRESSION_LEVEL == 1 {
         var hash uint32
         hash = q.hashat(source, s)
-        q.state2.hash[hash].offset = s
-        q.state2.hash_counter[hash] = 1
+        q.state.hash[hash].offset = s
+        q.state.hash_counter[hash] = 1
     } else if q._COMPRESSION_LEVEL == 2 {
         var hash uint32
         var c byte
         hash = q.hashat(source, s)
-        c = q.state2.hash_counter[hash]
-        q.state2.hash[hash].offset2[c & byte(q._POINTERS - 1)] = s
+        c = q.state.hash_counter[hash]
+        q.state.hash[hash].offset2[c & byte(q._POINTERS - 1)] = s
         c++
-        q.state2.hash_counter[hash] = c
+        q.state.hash_counter[hash] = c
     }
 }
 
-func (q *Qlz) update_hash_upto(source *[]byte, lh *int64, max int64) {
+func (q *Decompressor) update_hash_upto(source *[]byte, lh *int64, max int64) {
     for *lh < max {
         *lh++
         q.update_hash(source, *lh)
     }
 }
 
-func (q *Qlz) hash_func(i uint32) uint32 {
+func (q *config) hash_func(i uint32) uint32 {
     if q._COMPRESSION_LEVEL == 2 {
         return ((i >> 9) ^ (i >> 13) ^ i) & uint32(q._HASH_VALUES - 1)
     } else {
@@ -805,7 +1266,7 @@ func same(src *[]byte, src_index int64, n int64) int {
     return 0
 }
 
-func (q *Qlz) hashat(source *[]byte, src int64) uint32 {
+func (q *config) hashat(source *[]byte, src int64) uint32 {
     var fetch, hash uint32
     fetch = fast_read(source, src, 3)
     hash = q.hash_func(fetch)
@@ -0,0 +1,199 @@
+package quicklz
+
+import (
+    "bytes"
+    "errors"
+    "io/ioutil"
+    "testing"
+)
+
+func TestNonStandardStreamingBuffer(t *testing.T) {
+    src := test_data(300000)
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        q, err := New(level, 64 << 10)
+        if err != nil {
+            t.Fatal(err)
+        }
+        // Blocks of various sizes, some of them starting the history over
+        for off, n := 0, 1000; off < len(src); off, n = off + n, n * 3 / 2 {
+            if off + n > len(src) {
+                n = len(src) - off
+            }
+            part := src[off:off + n]
+            block, err := q.AppendCompress(nil, part)
+            if err != nil {
+                t.Fatal(err)
+            }
+            h, _ := ParseHeader(block)
+            if h.StreamingBuffer != STREAMING_BUFFER_OTHER {
+                t.Fatalf("level %d: header records streaming buffer %d", level, h.StreamingBuffer)
+            }
+            out, err := q.AppendDecompress(nil, block)
+            if err != nil || !bytes.Equal(out, part) {
+                t.Fatalf("level %d, %d bytes at %d: %v", level, n, off, err)
+            }
+        }
+    }
+}
+
+func TestOversizedStreamingBuffer(t *testing.T) {
+    for _, buf := range []uint{MaxBlockSize + 1, 1 << 62, STREAMING_BUFFER_OTHER} {
+        if _, err := NewCompressor(COMPRESSION_LEVEL_1, buf); !errors.Is(err, ErrInvalidStreamingBuffer) {
+            t.Errorf("compressor with buffer %d: got %v, want ErrInvalidStreamingBuffer", buf, err)
+        }
+        if _, err := NewDecompressor(COMPRESSION_LEVEL_2, buf); !errors.Is(err, ErrInvalidStreamingBuffer) {
+            t.Errorf("decompressor with buffer %d: got %v, want ErrInvalidStreamingBuffer", buf, err)
+        }
+        q, _ := New(COMPRESSION_LEVEL_3, STREAMING_BUFFER_0)
+        if err := q.Reconfigure(COMPRESSION_LEVEL_3, buf); !errors.Is(err, ErrInvalidStreamingBuffer) {
+            t.Errorf("reconfigure to buffer %d: got %v, want ErrInvalidStreamingBuffer", buf, err)
+        }
+    }
+}
+
+type test_config struct {
+    level uint
+    buf uint
+}
+
+// Blocks compressed by a fresh instance of the configuration
+func fresh_blocks(t *testing.T, cfg test_config, parts [][]byte) [][]byte {
+    c, err := NewCompressor(cfg.level, cfg.buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var blocks [][]byte
+    for _, p := range parts {
+        block, err := c.AppendCompress(nil, p)
+        if err != nil {
+            t.Fatal(err)
+        }
+        blocks = append(blocks, block)
+    }
+    return blocks
+}
+
+func TestReconfigure(t *testing.T) {
+    src := test_data(40000)
+    parts := [][]byte{src[:15000], src[15000:30000], src[30000:]}
+    configs := []test_config{
+        {COMPRESSION_LEVEL_3, STREAMING_BUFFER_1000000},
+        {COMPRESSION_LEVEL_1, STREAMING_BUFFER_100000},
+        {COMPRESSION_LEVEL_2, STREAMING_BUFFER_0},
+        {COMPRESSION_LEVEL_3, STREAMING_BUFFER_100000},
+        {COMPRESSION_LEVEL_2, STREAMING_BUFFER_1000000},
+        {COMPRESSION_LEVEL_1, 20000},
+        {COMPRESSION_LEVEL_2, 50000},
+        {COMPRESSION_LEVEL_1, STREAMING_BUFFER_0},
+    }
+    q, _ := New(COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+    c, _ := NewCompressor(COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+    d, _ := NewDecompressor(COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+    for _, cfg := range configs {
+        if err := q.Reconfigure(cfg.level, cfg.buf); err != nil {
+            t.Fatal(err)
+        }
+        if err := c.Reconfigure(cfg.level, cfg.buf); err != nil {
+            t.Fatal(err)
+        }
+        if err := d.Reconfigure(cfg.level, cfg.buf); err != nil {
+            t.Fatal(err)
+        }
+        // Nothing of the previous configuration is left over, the blocks
+        // match those of a new instance
+        want := fresh_blocks(t, cfg, parts)
+        for i, p := range parts {
+            block, err := q.AppendCompress(nil, p)
+            if err != nil || !bytes.Equal(block, want[i]) {
+                t.Fatalf("%+v, block %d: Qlz differs from a new instance: %v", cfg, i, err)
+            }
+            block, err = c.AppendCompress(nil, p)
+            if err != nil || !bytes.Equal(block, want[i]) {
+                t.Fatalf("%+v, block %d: Compressor differs from a new instance: %v", cfg, i, err)
+            }
+            out, err := q.AppendDecompress(nil, block)
+            if err != nil || !bytes.Equal(out, p) {
+                t.Fatalf("%+v, block %d: Qlz round trip: %v", cfg, i, err)
+            }
+            out, err = d.AppendDecompress(nil, block)
+            if err != nil || !bytes.Equal(out, p) {
+                t.Fatalf("%+v, block %d: Decompressor round trip: %v", cfg, i, err)
+            }
+        }
+    }
+}
+
+func TestResetStreaming(t *testing.T) {
+    src := test_data(40000)
+    parts := [][]byte{src[:15000], src[15000:30000], src[30000:]}
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        cfg := test_config{level, STREAMING_BUFFER_100000}
+        want := fresh_blocks(t, cfg, parts)
+        q, _ := New(level, STREAMING_BUFFER_100000)
+        for _, p := range parts {
+            block, _ := q.AppendCompress(nil, p)
+            q.AppendDecompress(nil, block)
+        }
+
+        q.Reset()
+        for i, p := range parts {
+            block, err := q.AppendCompress(nil, p)
+            if err != nil || !bytes.Equal(block, want[i]) {
+                t.Fatalf("level %d, block %d after Reset: differs from a new instance: %v", level, i, err)
+            }
+            out, err := q.AppendDecompress(nil, block)
+            if err != nil || !bytes.Equal(out, p) {
+                t.Fatalf("level %d, block %d after Reset: %v", level, i, err)
+            }
+        }
+
+        // Each side can be reset on its own
+        q.ResetCompressor()
+        block, _ := q.AppendCompress(nil, parts[0])
+        if !bytes.Equal(block, want[0]) {
+            t.Fatalf("level %d: ResetCompressor did not start a new stream", level)
+        }
+        q.ResetDecompressor()
+        out, err := q.AppendDecompress(nil, block)
+        if err != nil || !bytes.Equal(out, parts[0]) {
+            t.Fatalf("level %d: ResetDecompressor did not start a new stream: %v", level, err)
+        }
+    }
+}
+
+func TestEmptyInput(t *testing.T) {
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        for _, buf := range []uint{STREAMING_BUFFER_0, STREAMING_BUFFER_100000} {
+            q, _ := New(level, buf)
+            // Some history the empty block must not touch
+            block, _ := q.AppendCompress(nil, test_data(1000))
+            q.AppendDecompress(nil, block)
+            compressed := q.compressor.state.stream_counter
+            decompressed := q.decompressor.state.stream_counter
+
+            empty := []byte{}
+            dst := make([]byte, CompressBound(0))
+            n, err := q.Compress(&empty, &dst)
+            if err != nil || n != 3 {
+                t.Fatalf("level %d, buffer %d: compressed to %d bytes: %v", level, buf, n, err)
+            }
+            block = dst[:n]
+
+            out := []byte{}
+            if n, err := q.Decompress(&block, &out); err != nil || n != 0 {
+                t.Fatalf("level %d, buffer %d: Decompress returned %d: %v", level, buf, n, err)
+            }
+            if out, err := q.AppendDecompress([]byte("x"), block); err != nil || string(out) != "x" {
+                t.Fatalf("level %d, buffer %d: AppendDecompress returned %q: %v", level, buf, out, err)
+            }
+            if q.compressor.state.stream_counter != compressed || q.decompressor.state.stream_counter != decompressed {
+                t.Fatalf("level %d, buffer %d: empty block changed the history", level, buf)
+            }
+
+            r, _ := NewReader(bytes.NewReader(block), level, buf)
+            if out, err := ioutil.ReadAll(r); err != nil || len(out) != 0 {
+                t.Fatalf("level %d, buffer %d: Reader returned %d bytes: %v", level, buf, len(out), err)
+            }
+        }
+    }
+}
@@ -0,0 +1,95 @@
+package quicklz
+
+import (
+    "encoding/binary"
+    "fmt"
+    "io"
+    "sort"
+)
+
+// Largest payload of a metadata frame
+const MaxMetadataSize = 1 << 20
+
+// Write the key/value pairs in md as a metadata frame
+//
+// Buffered data is flushed first, so the frame sits between the blocks of
+// the data written before and after it. Readers that are not interested
+// skip the frame.
+func (z *FileWriter) WriteMetadata(md map[string]string) error {
+    if z.writer.closed {
+        return ErrClosed
+    }
+    if err := z.Flush(); err != nil {
+        return err
+    }
+    keys := make([]string, 0, len(md))
+    for k := range md {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    var payload []byte
+    for _, k := range keys {
+        payload = append_uint32(payload, uint32(len(k)))
+        payload = append(payload, k...)
+        payload = append_uint32(payload, uint32(len(md[k])))
+        payload = append(payload, md[k]...)
+        if len(payload) > MaxMetadataSize {
+            return fmt.Errorf("%w: metadata larger than MaxMetadataSize", ErrInputTooLarge)
+        }
+    }
+    return z.write_frame(_FRAME_METADATA, payload)
+}
+
+// Call fn with the pairs of each metadata frame as Read reaches it; without
+// a handler the frames are skipped
+func (z *FileReader) SetMetadataHandler(fn func(md map[string]string)) {
+    z.metadata = fn
+}
+
+func (z *FileReader) read_metadata() error {
+    n, err := z.read_frame_size()
+    if err != nil {
+        return err
+    }
+    if n > MaxMetadataSize {
+        return &CorruptError{0, "metadata frame too large"}
+    }
+    payload := make([]byte, n)
+    if _, err := io.ReadFull(z.reader.r, payload); err != nil {
+        return unexpected_eof(err)
+    }
+    md, err := parse_metadata(payload)
+    if err != nil {
+        return err
+    }
+    z.metadata(md)
+    return nil
+}
+
+func parse_metadata(payload []byte) (map[string]string, error) {
+    md := make(map[string]string)
+    next := func() (string, bool) {
+        if len(payload) < 4 {
+            return "", false
+        }
+        n := binary.LittleEndian.Uint32(payload)
+        if uint64(n) > uint64(len(payload) - 4) {
+            return "", false
+        }
+        s := string(payload[4:4+n])
+        payload = payload[4+n:]
+        return s, true
+    }
+    for len(payload) > 0 {
+        k, ok := next()
+        if !ok {
+            return nil, &CorruptError{0, "invalid metadata frame"}
+        }
+        v, ok := next()
+        if !ok {
+            return nil, &CorruptError{0, "invalid metadata frame"}
+        }
+        md[k] = v
+    }
+    return md, nil
+}
@@ -0,0 +1,6 @@
+//go:build !race
+// +build !race
+
+package quicklz
+
+const race_enabled = false
@@ -0,0 +1,67 @@
+package quicklz
+
+import (
+    "fmt"
+    "sync"
+)
+
+// Compression and decompression states for STREAMING_BUFFER_0 blocks, one
+// pool per compression level
+var compressor_pools [3]sync.Pool
+var decompressor_pools [3]sync.Pool
+
+// Compress src into a single STREAMING_BUFFER_0 block
+//
+// The state is taken from a shared pool, so unlike a Qlz this is safe for
+// concurrent use.
+func CompressBlock(compression_level uint, src []byte) ([]byte, error) {
+    return AppendCompressBlock(nil, compression_level, src)
+}
+
+// Compress src into a single STREAMING_BUFFER_0 block appended to dst
+func AppendCompressBlock(dst []byte, compression_level uint, src []byte) ([]byte, error) {
+    if compression_level < COMPRESSION_LEVEL_1 || compression_level > COMPRESSION_LEVEL_3 {
+        return dst, fmt.Errorf("%w (%d)", ErrInvalidLevel, compression_level)
+    }
+    pool := &compressor_pools[compression_level - 1]
+    c, ok := pool.Get().(*Compressor)
+    if !ok {
+        var err error
+        c, err = NewCompressor(compression_level, STREAMING_BUFFER_0)
+        if err != nil {
+            return dst, err
+        }
+    }
+    dst, err := c.AppendCompress(dst, src)
+    pool.Put(c)
+    return dst, err
+}
+
+// Decompress a single STREAMING_BUFFER_0 block of any compression level
+//
+// Like CompressBlock this is safe for concurrent use.
+func DecompressBlock(src []byte) ([]byte, error) {
+    return AppendDecompressBlock(nil, src)
+}
+
+// Decompress a single STREAMING_BUFFER_0 block and append the data to dst
+func AppendDecompressBlock(dst, src []byte) ([]byte, error) {
+    h, err := ParseHeader(src)
+    if err != nil {
+        return dst, err
+    }
+    if h.StreamingBuffer != STREAMING_BUFFER_0 {
+        return dst, ErrStreamingMismatch
+    }
+    pool := &decompressor_pools[h.Level - 1]
+    d, ok := pool.Get().(*Decompressor)
+    if !ok {
+        d, err = NewDecompressor(h.Level, STREAMING_BUFFER_0)
+        if err != nil {
+            return dst, err
+        }
+    }
+    dst, err = d.AppendDecompress(dst, src)
+    pool.Put(d)
+    return dst, err
+}
@@ -0,0 +1,33 @@
+package quicklz
+
+import (
+    "bytes"
+    "testing"
+)
+
+func TestBlockFunctionsDoNotAllocate(t *testing.T) {
+    if race_enabled {
+        t.Skip("sync.Pool does not keep its items under the race detector")
+    }
+    src := test_data(20000)
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        block := make([]byte, 0, CompressBound(len(src)))
+        out := make([]byte, 0, len(src))
+        var err error
+        compress := testing.AllocsPerRun(100, func() {
+            block, err = AppendCompressBlock(block[:0], level, src)
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        decompress := testing.AllocsPerRun(100, func() {
+            out, err = AppendDecompressBlock(out[:0], block)
+        })
+        if err != nil || !bytes.Equal(out, src) {
+            t.Fatalf("level %d: %v", level, err)
+        }
+        if compress != 0 || decompress != 0 {
+            t.Fatalf("level %d: %v allocations per compression, %v per decompression", level, compress, decompress)
+        }
+    }
+}
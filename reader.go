@@ -0,0 +1,153 @@
+package quicklz
+
+import (
+    "io"
+)
+
+const _READER_CHUNK = 65536
+
+// Reader decompresses a sequence of QuickLZ blocks read from an io.Reader
+type Reader struct {
+    decompressor *Decompressor
+    r io.Reader
+    header []byte
+    src []byte
+    out []byte
+    pos int
+    err error
+
+    // Set by framed formats: frame handles a frame whose first byte has bit 6
+    // clear, check is called with each block before it is decompressed and
+    // block after
+    frame func(t byte) error
+    check func(src []byte) error
+    block func(src, out []byte) error
+}
+
+// Create a streaming decompressor that reads QuickLZ blocks from r
+//
+// The compression level and streaming buffer must be the ones the data was
+// compressed with.
+func NewReader(r io.Reader, compression_level uint, streaming_buffer uint) (*Reader, error) {
+    d, err := NewDecompressor(compression_level, streaming_buffer)
+    if err != nil {
+        return nil, err
+    }
+    z := Reader{
+        decompressor: d,
+        r: r,
+        header: make([]byte, 9),
+    }
+    return &z, nil
+}
+
+// Read decompresses data into p, reading the next block when needed
+func (z *Reader) Read(p []byte) (int, error) {
+    if len(p) == 0 {
+        return 0, nil
+    }
+    for z.pos == len(z.out) {
+        if z.err != nil {
+            return 0, z.err
+        }
+        z.err = z.read_block()
+    }
+    n := copy(p, z.out[z.pos:])
+    z.pos += n
+    return n, nil
+}
+
+// Read one block and decompress it into z.out
+func (z *Reader) read_block() error {
+    z.out = z.out[:0]
+    z.pos = 0
+    if _, err := io.ReadFull(z.r, z.header[:1]); err != nil {
+        // A framed stream ends with its own end frame
+        if z.frame != nil {
+            return unexpected_eof(err)
+        }
+        return err
+    }
+    if z.frame != nil && (z.header[0] & (1 << 6)) == 0 {
+        return z.frame(z.header[0])
+    }
+    header_size := size_header(&z.header)
+    if _, err := io.ReadFull(z.r, z.header[1:header_size]); err != nil {
+        return unexpected_eof(err)
+    }
+    h, err := ParseHeader(z.header[:header_size])
+    if err != nil {
+        return err
+    }
+    c := h.CompressedSize
+    d := h.DecompressedSize
+
+    z.src = append(z.src[:0], z.header[:header_size]...)
+    if err := z.read_source(c); err != nil {
+        return err
+    }
+    if z.check != nil {
+        if err := z.check(z.src); err != nil {
+            return err
+        }
+    }
+
+    if cap(z.out) < d {
+        z.out = make([]byte, d)
+    }
+    z.out = z.out[:d]
+    // Nothing of a block that failed is returned
+    n, err := z.decompressor.Decompress(&z.src, &z.out)
+    if err != nil {
+        z.out = z.out[:0]
+        return err
+    }
+    if n != int64(d) {
+        z.out = z.out[:0]
+        return &CorruptError{0, "decompressed size does not match the header"}
+    }
+    if z.block != nil {
+        if err := z.block(z.src, z.out); err != nil {
+            z.out = z.out[:0]
+            return err
+        }
+    }
+    return nil
+}
+
+// Read the rest of a block of c bytes into z.src; the buffer grows as the
+// data arrives, so a truncated stream cannot make it allocate the size its
+// header claims
+func (z *Reader) read_source(c int) error {
+    for len(z.src) < c {
+        if len(z.src) == cap(z.src) {
+            n := 2 * cap(z.src)
+            if n < _READER_CHUNK {
+                n = _READER_CHUNK
+            }
+            if n > c {
+                n = c
+            }
+            grown := make([]byte, len(z.src), n)
+            copy(grown, z.src)
+            z.src = grown
+        }
+        end := cap(z.src)
+        if end > c {
+            end = c
+        }
+        m, err := io.ReadFull(z.r, z.src[len(z.src):end])
+        z.src = z.src[:len(z.src) + m]
+        if err != nil {
+            return unexpected_eof(err)
+        }
+    }
+    return nil
+}
+
+func unexpected_eof(err error) error {
+    if err == io.EOF || err == io.ErrUnexpectedEOF {
+        return ErrTruncated
+    }
+    return err
+}
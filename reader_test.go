@@ -0,0 +1,74 @@
+package quicklz
+
+import (
+    "bytes"
+    "errors"
+    "io/ioutil"
+    "testing"
+)
+
+func test_data(n int) []byte {
+    words := []string{"quick ", "lz ", "stream ", "block ", "header\n"}
+    b := make([]byte, 0, n)
+    for i := 0; len(b) < n; i++ {
+        b = append(b, words[(i * 7 + i / 5) % len(words)]...)
+        b = append(b, byte(i))
+    }
+    return b[:n]
+}
+
+func TestReaderCorruptBlock(t *testing.T) {
+    src := test_data(5000)
+    var buf bytes.Buffer
+    w, err := NewWriter(&buf, COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    w.Write(src)
+    w.Close()
+    block := buf.Bytes()
+
+    corrupt := 0
+    for i := 9; i < len(block); i++ {
+        bad := append([]byte(nil), block...)
+        bad[i] ^= 0xff
+        r, err := NewReader(bytes.NewReader(bad), COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+        if err != nil {
+            t.Fatal(err)
+        }
+        p := make([]byte, len(src))
+        n, err := r.Read(p)
+        if !errors.Is(err, ErrCorrupt) {
+            continue
+        }
+        corrupt++
+        if n != 0 {
+            t.Fatalf("byte %d: Read returned %d bytes with %v", i, n, err)
+        }
+        out, err := ioutil.ReadAll(r)
+        if len(out) != 0 || !errors.Is(err, ErrCorrupt) {
+            t.Fatalf("byte %d: ReadAll returned %d bytes with %v", i, len(out), err)
+        }
+    }
+    if corrupt == 0 {
+        t.Fatal("no corruption was detected")
+    }
+}
+
+func TestReaderTruncated(t *testing.T) {
+    // Header of a 1 GB block, without the block
+    src := []byte{0x47, 0, 0, 0, 0x40, 0, 0, 0, 0x40}
+    r, err := NewReader(bytes.NewReader(src), COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    n := allocated(func() {
+        _, err = r.Read(make([]byte, 100))
+    })
+    if !errors.Is(err, ErrTruncated) {
+        t.Fatalf("got %v, want ErrTruncated", err)
+    }
+    if n > 1 << 20 {
+        t.Fatalf("allocated %d bytes for a truncated block", n)
+    }
+}
@@ -0,0 +1,250 @@
+package quicklz
+
+import (
+    "encoding/binary"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "sort"
+    "sync"
+)
+
+// Create a writer of a seekable .qlz file
+//
+// The blocks are compressed independently with STREAMING_BUFFER_0 and the
+// file ends with an index of the blocks, so a SeekableReader can decompress
+// any part of it without starting from the beginning.
+func NewSeekableWriter(w io.Writer, compression_level uint) (*FileWriter, error) {
+    z, err := NewFileWriter(w, compression_level, STREAMING_BUFFER_0)
+    if err != nil {
+        return nil, err
+    }
+    z.flags |= FLAG_INDEX
+    return z, nil
+}
+
+// SeekableReader gives random access to the uncompressed content of a .qlz
+// file written by a SeekableWriter, decompressing only the blocks a read
+// touches
+//
+// ReadAt may be called concurrently, Read and Seek share one position. Block
+// checksums are verified, the content checksum is only checked by a
+// FileReader reading the whole file.
+type SeekableReader struct {
+    r io.ReaderAt
+    flags byte
+    size int64
+    blocks []seekable_block
+    end int64   // offset of the index frame, where the last block ends
+    pos int64
+
+    mu sync.Mutex
+    cached int   // index of the block in cache, -1 if none
+    cache []byte
+}
+
+type seekable_block struct {
+    offset int64        // offset of the block in the file
+    uncompressed int64  // offset of its data in the content
+}
+
+// Create a reader of the seekable .qlz file of the given size in r
+func NewSeekableReader(r io.ReaderAt, size int64) (*SeekableReader, error) {
+    header := make([]byte, _FILE_HEADER_SIZE)
+    if err := read_at(r, header, 0); err != nil {
+        return nil, err
+    }
+    flags, _, buf, err := parse_file_header(header)
+    if err != nil {
+        return nil, err
+    }
+    if (flags & FLAG_INDEX) == 0 || buf != STREAMING_BUFFER_0 {
+        return nil, fmt.Errorf("%w: file is not seekable", ErrInvalidFile)
+    }
+    z := SeekableReader{r: r, flags: flags, cached: -1}
+
+    n := int64(trailer_size(z.flags))
+    if size < _FILE_HEADER_SIZE + n {
+        return nil, ErrTruncated
+    }
+    trailer := make([]byte, n)
+    if err := read_at(r, trailer, size - n); err != nil {
+        return nil, err
+    }
+    if trailer[0] != _FRAME_END {
+        return nil, &CorruptError{0, "missing end frame"}
+    }
+    total := binary.LittleEndian.Uint64(trailer[1:9])
+    count := binary.LittleEndian.Uint64(trailer[9:17])
+    index := binary.LittleEndian.Uint64(trailer[n-8:])
+    if total > 1 << 62 || index < _FILE_HEADER_SIZE || index > uint64(size - n - 5) {
+        return nil, &CorruptError{0, "invalid trailer"}
+    }
+    z.size = int64(total)
+    z.end = int64(index)
+    if err := z.read_index(count, size - n); err != nil {
+        return nil, err
+    }
+    return &z, nil
+}
+
+func (z *SeekableReader) read_index(count uint64, end int64) error {
+    frame := make([]byte, 5)
+    if err := read_at(z.r, frame, z.end); err != nil {
+        return err
+    }
+    n := int64(binary.LittleEndian.Uint32(frame[1:5]))
+    if frame[0] != _FRAME_INDEX || n != end - z.end - 5 || n % 16 != 0 || count != uint64(n) / 16 {
+        return &CorruptError{0, "invalid index frame"}
+    }
+    payload := make([]byte, n)
+    if err := read_at(z.r, payload, z.end + 5); err != nil {
+        return err
+    }
+    z.blocks = make([]seekable_block, count)
+    offset, uncompressed := int64(_FILE_HEADER_SIZE), int64(0)
+    for i := range z.blocks {
+        b := seekable_block{
+            offset: int64(binary.LittleEndian.Uint64(payload[16*i:])),
+            uncompressed: int64(binary.LittleEndian.Uint64(payload[16*i+8:])),
+        }
+        // Blocks follow each other, possibly with skippable frames between
+        // them, and each one holds some data
+        if (i == 0 && (b.offset < offset || b.uncompressed != 0)) ||
+            (i > 0 && (b.offset <= offset || b.uncompressed <= uncompressed)) ||
+            b.offset >= z.end || b.uncompressed >= z.size {
+            return &CorruptError{0, "invalid index entry"}
+        }
+        z.blocks[i] = b
+        offset, uncompressed = b.offset, b.uncompressed
+    }
+    if count == 0 && z.size != 0 {
+        return &CorruptError{0, "invalid index entry"}
+    }
+    return nil
+}
+
+// ReadAt may return io.EOF along with a full read
+func read_at(r io.ReaderAt, p []byte, off int64) error {
+    n, err := r.ReadAt(p, off)
+    if n == len(p) {
+        return nil
+    }
+    if err == nil {
+        err = io.ErrUnexpectedEOF
+    }
+    return unexpected_eof(err)
+}
+
+// Size of the uncompressed content
+func (z *SeekableReader) Size() int64 {
+    return z.size
+}
+
+// ReadAt decompresses the content at offset off into p
+func (z *SeekableReader) ReadAt(p []byte, off int64) (int, error) {
+    if off < 0 {
+        return 0, fmt.Errorf("%w: negative offset", ErrInvalidOffset)
+    }
+    n := 0
+    for n < len(p) {
+        if off >= z.size {
+            return n, io.EOF
+        }
+        i := sort.Search(len(z.blocks), func(j int) bool {
+            return z.blocks[j].uncompressed > off
+        }) - 1
+        m, err := z.copy_block(i, p[n:], off - z.blocks[i].uncompressed)
+        if err != nil {
+            return n, err
+        }
+        n += m
+        off += int64(m)
+    }
+    return n, nil
+}
+
+// Copy the data of block i from offset off into p; the last decompressed
+// block is kept so sequential reads decompress each block once
+func (z *SeekableReader) copy_block(i int, p []byte, off int64) (int, error) {
+    z.mu.Lock()
+    defer z.mu.Unlock()
+    if z.cached != i {
+        z.cached = -1
+        data, err := z.read_block(i)
+        if err != nil {
+            return 0, err
+        }
+        z.cache = data
+        z.cached = i
+    }
+    return copy(p, z.cache[off:]), nil
+}
+
+func (z *SeekableReader) read_block(i int) ([]byte, error) {
+    start := z.blocks[i].offset
+    end, size := z.end, z.size
+    if i + 1 < len(z.blocks) {
+        end, size = z.blocks[i+1].offset, z.blocks[i+1].uncompressed
+    }
+    // Skippable frames may follow the block up to the next one
+    src := make([]byte, end - start)
+    if err := read_at(z.r, src, start); err != nil {
+        return nil, err
+    }
+    c, err := SizeCompressed(src)
+    if err != nil {
+        return nil, &CorruptError{0, "block does not match the index"}
+    }
+    if (z.flags & FLAG_BLOCK_CHECKSUM) != 0 {
+        if c + 4 > len(src) {
+            return nil, &CorruptError{0, "block does not match the index"}
+        }
+        expected := binary.LittleEndian.Uint32(src[c:c+4])
+        if got := crc32.Checksum(src[:c], crc32c); got != expected {
+            return nil, &ChecksumError{Block: int64(i), Expected: expected, Got: got}
+        }
+    } else if c > len(src) {
+        return nil, &CorruptError{0, "block does not match the index"}
+    }
+    src = src[:c]
+    data, err := AppendDecompressBlock(z.cache[:0], src)
+    if err != nil {
+        return nil, err
+    }
+    if int64(len(data)) != size - z.blocks[i].uncompressed {
+        return nil, &CorruptError{0, "block does not match the index"}
+    }
+    return data, nil
+}
+
+// Read decompresses the content at the current position into p
+func (z *SeekableReader) Read(p []byte) (int, error) {
+    if len(p) == 0 {
+        return 0, nil
+    }
+    n, err := z.ReadAt(p, z.pos)
+    z.pos += int64(n)
+    if n > 0 && err == io.EOF {
+        err = nil
+    }
+    return n, err
+}
+
+// Seek sets the position of the next Read in the uncompressed content
+func (z *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+    switch whence {
+    case io.SeekStart:
+    case io.SeekCurrent:
+        offset += z.pos
+    case io.SeekEnd:
+        offset += z.size
+    default:
+        return 0, fmt.Errorf("%w: invalid whence", ErrInvalidOffset)
+    }
+    if offset < 0 {
+        return 0, fmt.Errorf("%w: negative position", ErrInvalidOffset)
+    }
+    z.pos = offset
+    return offset, nil
+}
@@ -0,0 +1,118 @@
+package quicklz
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "io"
+    "io/ioutil"
+    "testing"
+)
+
+func TestSeekableReader(t *testing.T) {
+    src := test_data(500000)
+    for _, checksums := range []bool{false, true} {
+        var buf bytes.Buffer
+        w, err := NewSeekableWriter(&buf, COMPRESSION_LEVEL_2)
+        if err != nil {
+            t.Fatal(err)
+        }
+        w.SetChecksums(checksums, checksums)
+        w.Write(src[:70000])
+        w.WriteMetadata(map[string]string{"part": "2"})
+        w.Write(src[70000:])
+        w.Close()
+        file := buf.Bytes()
+
+        r, err := NewSeekableReader(bytes.NewReader(file), int64(len(file)))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if r.Size() != int64(len(src)) {
+            t.Fatalf("size %d, want %d", r.Size(), len(src))
+        }
+        for _, off := range []int64{0, 1, 65535, 65536, 69999, 300000, int64(len(src)) - 10} {
+            p := make([]byte, 100000)
+            n, err := r.ReadAt(p, off)
+            want := int64(len(src)) - off
+            if want > int64(len(p)) {
+                want = int64(len(p))
+            }
+            if int64(n) != want || (n < len(p) && err != io.EOF) {
+                t.Fatalf("ReadAt(%d) = %d, %v", off, n, err)
+            }
+            if !bytes.Equal(p[:n], src[off:off + int64(n)]) {
+                t.Fatalf("ReadAt(%d) returned wrong data", off)
+            }
+        }
+        if _, err := r.ReadAt(make([]byte, 1), -1); !errors.Is(err, ErrInvalidOffset) {
+            t.Fatalf("ReadAt(-1): got %v, want ErrInvalidOffset", err)
+        }
+        if _, err := r.Seek(-1, io.SeekStart); !errors.Is(err, ErrInvalidOffset) {
+            t.Fatalf("Seek(-1): got %v, want ErrInvalidOffset", err)
+        }
+        if _, err := r.Seek(0, 3); !errors.Is(err, ErrInvalidOffset) {
+            t.Fatalf("Seek with an invalid whence: got %v, want ErrInvalidOffset", err)
+        }
+        r.Seek(400000, io.SeekStart)
+        out, err := ioutil.ReadAll(r)
+        if err != nil || !bytes.Equal(out, src[400000:]) {
+            t.Fatalf("Seek and Read: %v", err)
+        }
+
+        // The sequential reader skips the index
+        out, err = read_file(file)
+        if err != nil || !bytes.Equal(out, src) {
+            t.Fatalf("FileReader: %v", err)
+        }
+    }
+}
+
+func TestSeekableReaderIndexCount(t *testing.T) {
+    // An empty index with a trailer claiming 1<<60 blocks
+    var file []byte
+    file = append(file, file_magic...)
+    file = append(file, FileVersion, FLAG_INDEX, COMPRESSION_LEVEL_1)
+    file = append_uint64(file, STREAMING_BUFFER_0)
+    file = append(file, _FRAME_INDEX)
+    file = append_uint32(file, 0)
+    file = append(file, _FRAME_END)
+    file = append_uint64(file, 0)
+    file = append_uint64(file, 1 << 60)
+    file = append_uint64(file, _FILE_HEADER_SIZE)
+
+    _, err := NewSeekableReader(bytes.NewReader(file), int64(len(file)))
+    if !errors.Is(err, ErrCorrupt) {
+        t.Fatalf("got %v, want ErrCorrupt", err)
+    }
+}
+
+func TestSeekableReaderHeader(t *testing.T) {
+    var buf bytes.Buffer
+    w, _ := NewSeekableWriter(&buf, COMPRESSION_LEVEL_1)
+    w.Write(test_data(1000))
+    w.Close()
+    file := buf.Bytes()
+
+    modify := func(f func(b []byte)) []byte {
+        b := append([]byte(nil), file...)
+        f(b)
+        return b
+    }
+    tests := []struct {
+        name string
+        file []byte
+    }{
+        {"bad magic", modify(func(b []byte) { b[1] = 'X' })},
+        {"bad version", modify(func(b []byte) { b[4] = FileVersion + 1 })},
+        {"unknown flags", modify(func(b []byte) { b[5] |= 0x80 })},
+        {"oversized streaming buffer", modify(func(b []byte) { binary.LittleEndian.PutUint64(b[7:], 1 << 62) })},
+        {"streaming buffer", modify(func(b []byte) { binary.LittleEndian.PutUint64(b[7:], STREAMING_BUFFER_100000) })},
+        {"no index", modify(func(b []byte) { b[5] &^= FLAG_INDEX })},
+    }
+    for _, tt := range tests {
+        if _, err := NewSeekableReader(bytes.NewReader(tt.file), int64(len(tt.file))); !errors.Is(err, ErrInvalidFile) {
+            t.Errorf("%s: got %v, want ErrInvalidFile", tt.name, err)
+        }
+    }
+}
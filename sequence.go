@@ -0,0 +1,122 @@
+package quicklz
+
+import (
+    "encoding/binary"
+    "hash/crc32"
+)
+
+// Size of the frame header added by SequencedCompressor: the sequence number
+// (8 bytes) and the history fingerprint (4 bytes), little endian
+const SequenceHeaderSize = 12
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// SequencedCompressor prefixes every block with a sequence number and a
+// fingerprint of the history it was compressed against, so a
+// SequencedDecompressor detects lost, duplicated or reordered blocks before
+// they corrupt its history
+type SequencedCompressor struct {
+    compressor *Compressor
+    seq uint64
+    fingerprint uint32
+}
+
+// SequencedDecompressor decompresses the frames of a SequencedCompressor
+type SequencedDecompressor struct {
+    decompressor *Decompressor
+    seq uint64
+    fingerprint uint32
+}
+
+// Create new compressor emitting sequenced frames
+func NewSequencedCompressor(compression_level uint, streaming_buffer uint) (*SequencedCompressor, error) {
+    c, err := NewCompressor(compression_level, streaming_buffer)
+    if err != nil {
+        return nil, err
+    }
+    return &SequencedCompressor{compressor: c}, nil
+}
+
+// Create new decompressor for sequenced frames
+func NewSequencedDecompressor(compression_level uint, streaming_buffer uint) (*SequencedDecompressor, error) {
+    d, err := NewDecompressor(compression_level, streaming_buffer)
+    if err != nil {
+        return nil, err
+    }
+    return &SequencedDecompressor{decompressor: d}, nil
+}
+
+// Compress src and append the frame to dst
+func (s *SequencedCompressor) AppendCompress(dst, src []byte) ([]byte, error) {
+    n := len(dst)
+    var header [SequenceHeaderSize]byte
+    binary.LittleEndian.PutUint64(header[0:8], s.seq)
+    binary.LittleEndian.PutUint32(header[8:12], s.fingerprint)
+    dst, err := s.compressor.AppendCompress(append(dst, header[:]...), src)
+    if err != nil {
+        return dst[:n], err
+    }
+    s.seq++
+    s.fingerprint = next_fingerprint(&s.compressor.config, s.compressor.state.stream_counter, s.compressor.primed != nil, s.fingerprint, src)
+    return dst, nil
+}
+
+// Sequence number of the next frame
+func (s *SequencedCompressor) Sequence() uint64 {
+    return s.seq
+}
+
+// Reset the history and start again from sequence number 0
+func (s *SequencedCompressor) Reset() {
+    s.compressor.Reset()
+    s.seq = 0
+    s.fingerprint = 0
+}
+
+// Decompress the frame in src and append the data to dst
+//
+// A frame out of sequence is rejected with a *SequenceError and a frame
+// compressed against another history with ErrHistoryMismatch; the state is
+// left as it was in both cases, so the expected frame can still follow.
+func (s *SequencedDecompressor) AppendDecompress(dst, src []byte) ([]byte, error) {
+    if len(src) < SequenceHeaderSize {
+        return dst, ErrTruncated
+    }
+    seq := binary.LittleEndian.Uint64(src[0:8])
+    if seq != s.seq {
+        return dst, &SequenceError{Expected: s.seq, Got: seq}
+    }
+    if binary.LittleEndian.Uint32(src[8:12]) != s.fingerprint {
+        return dst, ErrHistoryMismatch
+    }
+    n := len(dst)
+    dst, err := s.decompressor.AppendDecompress(dst, src[SequenceHeaderSize:])
+    if err != nil {
+        return dst, err
+    }
+    s.seq++
+    s.fingerprint = next_fingerprint(&s.decompressor.config, s.decompressor.state.stream_counter, s.decompressor.primed != nil, s.fingerprint, dst[n:])
+    return dst, nil
+}
+
+// Sequence number of the next expected frame
+func (s *SequencedDecompressor) Sequence() uint64 {
+    return s.seq
+}
+
+// Reset the history and expect sequence number 0 next
+func (s *SequencedDecompressor) Reset() {
+    s.decompressor.Reset()
+    s.seq = 0
+    s.fingerprint = 0
+}
+
+// The fingerprint is the CRC-32C of the data since the history was last
+// started over; both sides start over on the same blocks, which leave the
+// stream counter at 0 (or at the dictionary when primed).
+func next_fingerprint(q *config, stream_counter int64, primed bool, fingerprint uint32, data []byte) uint32 {
+    if q._STREAMING_BUFFER == 0 || stream_counter == 0 || primed {
+        return 0
+    }
+    return crc32.Update(fingerprint, crc32c, data)
+}
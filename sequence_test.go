@@ -0,0 +1,100 @@
+package quicklz
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+)
+
+func sequenced_frames(t *testing.T, level uint, buf uint, parts ...[]byte) [][]byte {
+    c, err := NewSequencedCompressor(level, buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var frames [][]byte
+    for _, p := range parts {
+        frame, err := c.AppendCompress(nil, p)
+        if err != nil {
+            t.Fatal(err)
+        }
+        frames = append(frames, frame)
+    }
+    return frames
+}
+
+// Rejected frames leave the decompressor as it was, so the stream goes on
+// as soon as the expected frame arrives
+func TestSequencedRejectedFrames(t *testing.T) {
+    src := test_data(40000)
+    parts := [][]byte{src[:10000], src[10000:20000], src[20000:30000], src[30000:]}
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        frames := sequenced_frames(t, level, STREAMING_BUFFER_100000, parts...)
+        d, err := NewSequencedDecompressor(level, STREAMING_BUFFER_100000)
+        if err != nil {
+            t.Fatal(err)
+        }
+        expect := func(i int) {
+            out, err := d.AppendDecompress(nil, frames[i])
+            if err != nil || !bytes.Equal(out, parts[i]) {
+                t.Fatalf("level %d, frame %d: %v", level, i, err)
+            }
+        }
+        reject := func(i int, expected uint64) {
+            dst := []byte("prefix")
+            out, err := d.AppendDecompress(dst, frames[i])
+            var e *SequenceError
+            if !errors.As(err, &e) || !errors.Is(err, ErrSequence) || e.Expected != expected || e.Got != uint64(i) {
+                t.Fatalf("level %d, frame %d: got %v, want a SequenceError expecting %d", level, i, err, expected)
+            }
+            if string(out) != "prefix" || d.Sequence() != expected {
+                t.Fatalf("level %d, frame %d: rejected frame changed the output or the sequence", level, i)
+            }
+        }
+
+        expect(0)
+        reject(0, 1)   // duplicated
+        reject(2, 1)   // lost or reordered
+        reject(3, 1)
+        expect(1)
+        expect(2)
+        reject(1, 3)   // late
+        expect(3)
+    }
+}
+
+func TestSequencedHistoryMismatch(t *testing.T) {
+    src := test_data(30000)
+    a := sequenced_frames(t, COMPRESSION_LEVEL_1, STREAMING_BUFFER_100000, src[:10000], src[10000:20000])
+    // Same sequence numbers, compressed after other data
+    b := sequenced_frames(t, COMPRESSION_LEVEL_1, STREAMING_BUFFER_100000, src[20000:], src[10000:20000])
+
+    d, _ := NewSequencedDecompressor(COMPRESSION_LEVEL_1, STREAMING_BUFFER_100000)
+    if _, err := d.AppendDecompress(nil, a[0]); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := d.AppendDecompress(nil, b[1]); !errors.Is(err, ErrHistoryMismatch) {
+        t.Fatalf("got %v, want ErrHistoryMismatch", err)
+    }
+    if d.Sequence() != 1 {
+        t.Fatalf("sequence moved to %d after a rejected frame", d.Sequence())
+    }
+    out, err := d.AppendDecompress(nil, a[1])
+    if err != nil || !bytes.Equal(out, src[10000:20000]) {
+        t.Fatalf("expected frame after a mismatch: %v", err)
+    }
+}
+
+func TestSequencedReset(t *testing.T) {
+    src := test_data(20000)
+    frames := sequenced_frames(t, COMPRESSION_LEVEL_2, STREAMING_BUFFER_100000, src[:10000], src[10000:])
+    d, _ := NewSequencedDecompressor(COMPRESSION_LEVEL_2, STREAMING_BUFFER_100000)
+    d.AppendDecompress(nil, frames[0])
+    d.Reset()
+    if _, err := d.AppendDecompress(nil, frames[1]); !errors.Is(err, ErrSequence) {
+        t.Fatalf("got %v, want ErrSequence", err)
+    }
+    out, err := d.AppendDecompress(nil, frames[0])
+    if err != nil || !bytes.Equal(out, src[:10000]) {
+        t.Fatalf("first frame after Reset: %v", err)
+    }
+}
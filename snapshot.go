@@ -0,0 +1,369 @@
+package quicklz
+
+import (
+    "encoding/binary"
+    "fmt"
+    "hash/crc32"
+)
+
+// Snapshot layout, all integers little endian:
+//
+//   magic "QLZS", version, kind, level, streaming buffer (8 bytes)
+//   kind 'Q': presence flags (bit 0 compressor, bit 1 decompressor)
+//   then the state of each present side
+//   CRC-32 (IEEE) of everything before it
+//
+// The dictionary is not part of a snapshot, MarshalBinary returns
+// ErrSnapshotDictionary for a primed instance, which is recreated with the
+// same dictionary instead.
+//
+// With STREAMING_BUFFER_0 there is no history and the state is empty.
+// Otherwise it holds the stream counter (8 bytes), the stream buffer up to
+// the counter and the hash tables of the level.
+const _SNAPSHOT_VERSION = 1
+
+const (
+    _SNAPSHOT_QLZ = 'Q'
+    _SNAPSHOT_COMPRESSOR = 'C'
+    _SNAPSHOT_DECOMPRESSOR = 'D'
+)
+
+var snapshot_magic = []byte("QLZS")
+
+type snapshot_reader struct {
+    b []byte
+    err error
+}
+
+func (r *snapshot_reader) next(n int) []byte {
+    if r.err != nil {
+        return nil
+    }
+    if n < 0 || len(r.b) < n {
+        r.err = fmt.Errorf("%w: unexpected end of data", ErrInvalidSnapshot)
+        return nil
+    }
+    p := r.b[:n]
+    r.b = r.b[n:]
+    return p
+}
+
+func (r *snapshot_reader) uint8() byte {
+    p := r.next(1)
+    if p == nil {
+        return 0
+    }
+    return p[0]
+}
+
+func (r *snapshot_reader) uint32() uint32 {
+    p := r.next(4)
+    if p == nil {
+        return 0
+    }
+    return binary.LittleEndian.Uint32(p)
+}
+
+func (r *snapshot_reader) uint64() uint64 {
+    p := r.next(8)
+    if p == nil {
+        return 0
+    }
+    return binary.LittleEndian.Uint64(p)
+}
+
+func append_uint32(b []byte, v uint32) []byte {
+    var p [4]byte
+    binary.LittleEndian.PutUint32(p[:], v)
+    return append(b, p[:]...)
+}
+
+func append_uint64(b []byte, v uint64) []byte {
+    var p [8]byte
+    binary.LittleEndian.PutUint64(p[:], v)
+    return append(b, p[:]...)
+}
+
+func (q *config) append_snapshot_header(b []byte, kind byte) []byte {
+    b = append(b, snapshot_magic...)
+    b = append(b, _SNAPSHOT_VERSION, kind, byte(q._COMPRESSION_LEVEL))
+    return append_uint64(b, uint64(q._STREAMING_BUFFER))
+}
+
+func append_snapshot_checksum(b []byte) []byte {
+    return append_uint32(b, crc32.ChecksumIEEE(b))
+}
+
+// The checksum only catches accidental damage, anyone can recompute it; the
+// header is validated on its own before the state is allocated
+func new_snapshot_reader(data []byte) *snapshot_reader {
+    n := len(data) - 4
+    if n < 0 || crc32.ChecksumIEEE(data[:n]) != binary.LittleEndian.Uint32(data[n:]) {
+        return &snapshot_reader{err: fmt.Errorf("%w: checksum mismatch", ErrInvalidSnapshot)}
+    }
+    return &snapshot_reader{b: data[:n]}
+}
+
+func read_snapshot_header(r *snapshot_reader, kind byte) (config, error) {
+    magic := r.next(len(snapshot_magic))
+    version := r.uint8()
+    k := r.uint8()
+    level := r.uint8()
+    buf := r.uint64()
+    if r.err != nil {
+        return config{}, r.err
+    }
+    if string(magic) != string(snapshot_magic) {
+        return config{}, fmt.Errorf("%w: bad magic", ErrInvalidSnapshot)
+    }
+    if version != _SNAPSHOT_VERSION {
+        return config{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, version)
+    }
+    if k != kind {
+        return config{}, fmt.Errorf("%w: snapshot of another type", ErrInvalidSnapshot)
+    }
+    // The state is allocated from the header, a crafted snapshot could
+    // otherwise ask for an arbitrarily large streaming buffer
+    if buf > MaxBlockSize || uint64(uint(buf)) != buf || uint(buf) == STREAMING_BUFFER_OTHER {
+        return config{}, fmt.Errorf("%w: streaming buffer %d", ErrInvalidSnapshot, buf)
+    }
+    cfg, err := new_config(uint(level), uint(buf))
+    if err != nil {
+        return config{}, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+    }
+    return cfg, nil
+}
+
+func read_snapshot_end(r *snapshot_reader) error {
+    if r.err == nil && len(r.b) != 0 {
+        r.err = fmt.Errorf("%w: trailing data", ErrInvalidSnapshot)
+    }
+    return r.err
+}
+
+// Encode the compression state, including the streaming history
+func (q *Compressor) MarshalBinary() ([]byte, error) {
+    if q.primed != nil {
+        return nil, ErrSnapshotDictionary
+    }
+    b := q.append_snapshot_header(nil, _SNAPSHOT_COMPRESSOR)
+    return append_snapshot_checksum(q.append_state(b)), nil
+}
+
+// Restore a state encoded by MarshalBinary, replacing the configuration and
+// history of q; q is left untouched if data is invalid
+func (q *Compressor) UnmarshalBinary(data []byte) error {
+    r := new_snapshot_reader(data)
+    cfg, err := read_snapshot_header(r, _SNAPSHOT_COMPRESSOR)
+    if err != nil {
+        return err
+    }
+    c := cfg.new_compressor()
+    c.read_state(r)
+    if err = read_snapshot_end(r); err != nil {
+        return err
+    }
+    *q = *c
+    return nil
+}
+
+func (q *Compressor) append_state(b []byte) []byte {
+    if q._STREAMING_BUFFER == 0 {
+        return b
+    }
+    s := q.state
+    b = append_uint64(b, uint64(s.stream_counter))
+    b = append(b, s.stream_buffer[:s.stream_counter]...)
+    if q._COMPRESSION_LEVEL == 1 {
+        for i := range s.hash {
+            b = append_uint32(b, s.hash[i].cache)
+            b = append_uint64(b, uint64(s.hash[i].offset))
+        }
+        return b
+    }
+    // Only the slots below the counter are ever read before being written
+    b = append(b, s.hash_counter...)
+    for i := range s.hash {
+        for k := uint(0); k < q._POINTERS && k < uint(s.hash_counter[i]); k++ {
+            b = append_uint64(b, uint64(s.hash[i].offset2[k]))
+        }
+    }
+    return b
+}
+
+// The offsets are used to index the history directly, so every one that
+// can be read must point before the end of the history
+func (q *Compressor) read_state(r *snapshot_reader) {
+    if q._STREAMING_BUFFER == 0 {
+        return
+    }
+    s := q.state
+    counter := r.uint64()
+    if r.err == nil && counter > uint64(q._STREAMING_BUFFER) {
+        r.err = fmt.Errorf("%w: stream counter %d", ErrInvalidSnapshot, counter)
+    }
+    if r.err != nil {
+        return
+    }
+    s.stream_counter = int64(counter)
+    copy(s.stream_buffer, r.next(int(counter)))
+    valid := func(o int64) bool {
+        return o >= 0 && o < s.stream_counter
+    }
+    if q._COMPRESSION_LEVEL == 1 {
+        for i := range s.hash {
+            s.hash[i].cache = r.uint32()
+            s.hash[i].offset = int64(r.uint64())
+            if r.err == nil && s.hash[i].offset != q.offset_base && !valid(s.hash[i].offset) {
+                r.err = fmt.Errorf("%w: hash offset out of range", ErrInvalidSnapshot)
+            }
+        }
+        return
+    }
+    copy(s.hash_counter, r.next(len(s.hash_counter)))
+    if r.err != nil {
+        return
+    }
+    for i := range s.hash {
+        for k := uint(0); k < q._POINTERS && k < uint(s.hash_counter[i]); k++ {
+            s.hash[i].offset2[k] = int64(r.uint64())
+            if r.err == nil && !valid(s.hash[i].offset2[k]) {
+                r.err = fmt.Errorf("%w: hash offset out of range", ErrInvalidSnapshot)
+            }
+        }
+    }
+}
+
+// Encode the decompression state, including the streaming history
+func (q *Decompressor) MarshalBinary() ([]byte, error) {
+    if q.primed != nil {
+        return nil, ErrSnapshotDictionary
+    }
+    b := q.append_snapshot_header(nil, _SNAPSHOT_DECOMPRESSOR)
+    return append_snapshot_checksum(q.append_state(b)), nil
+}
+
+// Restore a state encoded by MarshalBinary, replacing the configuration and
+// history of q; q is left untouched if data is invalid
+func (q *Decompressor) UnmarshalBinary(data []byte) error {
+    r := new_snapshot_reader(data)
+    cfg, err := read_snapshot_header(r, _SNAPSHOT_DECOMPRESSOR)
+    if err != nil {
+        return err
+    }
+    d := cfg.new_decompressor()
+    d.read_state(r)
+    if err = read_snapshot_end(r); err != nil {
+        return err
+    }
+    *q = *d
+    return nil
+}
+
+func (q *Decompressor) append_state(b []byte) []byte {
+    if q._STREAMING_BUFFER == 0 {
+        return b
+    }
+    s := q.state
+    b = append_uint64(b, uint64(s.stream_counter))
+    b = append(b, s.stream_buffer[:s.stream_counter]...)
+    if q._COMPRESSION_LEVEL == 1 {
+        for i := range s.hash {
+            b = append_uint64(b, uint64(s.hash[i].offset))
+        }
+    } else if q._COMPRESSION_LEVEL == 2 {
+        b = append(b, s.hash_counter...)
+        for i := range s.hash {
+            for _, o := range s.hash[i].offset2 {
+                b = append_uint64(b, uint64(o))
+            }
+        }
+    }
+    return b
+}
+
+// The offsets are checked against the history by decompress_core when they
+// are used, so they are restored as is
+func (q *Decompressor) read_state(r *snapshot_reader) {
+    if q._STREAMING_BUFFER == 0 {
+        return
+    }
+    s := q.state
+    counter := r.uint64()
+    if r.err == nil && counter > uint64(q._STREAMING_BUFFER) {
+        r.err = fmt.Errorf("%w: stream counter %d", ErrInvalidSnapshot, counter)
+    }
+    if r.err != nil {
+        return
+    }
+    // The history has to be there before the buffer is grown for it
+    history := r.next(int(counter))
+    if r.err != nil {
+        return
+    }
+    s.stream_counter = int64(counter)
+    q.grow_stream_buffer(int64(counter))
+    copy(s.stream_buffer, history)
+    if q._COMPRESSION_LEVEL == 1 {
+        for i := range s.hash {
+            s.hash[i].offset = int64(r.uint64())
+        }
+    } else if q._COMPRESSION_LEVEL == 2 {
+        copy(s.hash_counter, r.next(len(s.hash_counter)))
+        for i := range s.hash {
+            for k := range s.hash[i].offset2 {
+                s.hash[i].offset2[k] = int64(r.uint64())
+            }
+        }
+    }
+}
+
+// Encode the configuration and the state of both sides
+func (q *Qlz) MarshalBinary() ([]byte, error) {
+    if (q.compressor != nil && q.compressor.primed != nil) ||
+        (q.decompressor != nil && q.decompressor.primed != nil) {
+        return nil, ErrSnapshotDictionary
+    }
+    b := q.append_snapshot_header(nil, _SNAPSHOT_QLZ)
+    flags := byte(0)
+    if q.compressor != nil {
+        flags |= 1
+    }
+    if q.decompressor != nil {
+        flags |= 2
+    }
+    b = append(b, flags)
+    if q.compressor != nil {
+        b = q.compressor.append_state(b)
+    }
+    if q.decompressor != nil {
+        b = q.decompressor.append_state(b)
+    }
+    return append_snapshot_checksum(b), nil
+}
+
+// Restore a state encoded by MarshalBinary, replacing the configuration and
+// history of q; q is left untouched if data is invalid
+func (q *Qlz) UnmarshalBinary(data []byte) error {
+    r := new_snapshot_reader(data)
+    cfg, err := read_snapshot_header(r, _SNAPSHOT_QLZ)
+    if err != nil {
+        return err
+    }
+    z := Qlz{config: cfg}
+    flags := r.uint8()
+    if r.err == nil && flags > 3 {
+        r.err = fmt.Errorf("%w: invalid flags", ErrInvalidSnapshot)
+    }
+    if r.err == nil && (flags & 1) != 0 {
+        z.get_compressor().read_state(r)
+    }
+    if r.err == nil && (flags & 2) != 0 {
+        z.get_decompressor().read_state(r)
+    }
+    if err = read_snapshot_end(r); err != nil {
+        return err
+    }
+    *q = z
+    return nil
+}
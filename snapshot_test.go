@@ -0,0 +1,182 @@
+package quicklz
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "hash/crc32"
+    "testing"
+)
+
+var snapshot_levels = []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3}
+var snapshot_buffers = []uint{STREAMING_BUFFER_0, STREAMING_BUFFER_100000, STREAMING_BUFFER_1000000, 50000}
+
+// Recompute the checksum of a snapshot modified by a test
+func resum(b []byte) []byte {
+    n := len(b) - 4
+    binary.LittleEndian.PutUint32(b[n:], crc32.ChecksumIEEE(b[:n]))
+    return b
+}
+
+// A restored compressor must produce the same blocks as the original and
+// a restored decompressor must decompress them
+func TestSnapshotRoundTrip(t *testing.T) {
+    src := test_data(60000)
+    for _, level := range snapshot_levels {
+        for _, buf := range snapshot_buffers {
+            c, _ := NewCompressor(level, buf)
+            d, _ := NewDecompressor(level, buf)
+            for i := 0; i < 3; i++ {
+                block, err := c.AppendCompress(nil, src[i*10000:(i+1)*10000])
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if _, err := d.AppendDecompress(nil, block); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            cs, err := c.MarshalBinary()
+            if err != nil {
+                t.Fatal(err)
+            }
+            ds, err := d.MarshalBinary()
+            if err != nil {
+                t.Fatal(err)
+            }
+            var c2 Compressor
+            var d2 Decompressor
+            if err := c2.UnmarshalBinary(cs); err != nil {
+                t.Fatalf("level %d, buffer %d: %v", level, buf, err)
+            }
+            if err := d2.UnmarshalBinary(ds); err != nil {
+                t.Fatalf("level %d, buffer %d: %v", level, buf, err)
+            }
+
+            for i := 3; i < 6; i++ {
+                part := src[i*10000:(i+1)*10000]
+                want, _ := c.AppendCompress(nil, part)
+                got, err := c2.AppendCompress(nil, part)
+                if err != nil || !bytes.Equal(got, want) {
+                    t.Fatalf("level %d, buffer %d, block %d: restored compressor differs: %v", level, buf, i, err)
+                }
+                out, err := d2.AppendDecompress(nil, got)
+                if err != nil || !bytes.Equal(out, part) {
+                    t.Fatalf("level %d, buffer %d, block %d: restored decompressor failed: %v", level, buf, i, err)
+                }
+            }
+        }
+    }
+}
+
+func TestSnapshotRoundTripQlz(t *testing.T) {
+    src := test_data(30000)
+    for _, level := range snapshot_levels {
+        q, _ := New(level, STREAMING_BUFFER_100000)
+        block, _ := q.AppendCompress(nil, src[:10000])
+        q.AppendDecompress(nil, block)
+        s, err := q.MarshalBinary()
+        if err != nil {
+            t.Fatal(err)
+        }
+        var q2 Qlz
+        if err := q2.UnmarshalBinary(s); err != nil {
+            t.Fatal(err)
+        }
+        block, _ = q.AppendCompress(nil, src[10000:])
+        got, err := q2.AppendCompress(nil, src[10000:])
+        if err != nil || !bytes.Equal(got, block) {
+            t.Fatalf("level %d: restored compressor differs: %v", level, err)
+        }
+        out, err := q2.AppendDecompress(nil, block)
+        if err != nil || !bytes.Equal(out, src[10000:]) {
+            t.Fatalf("level %d: restored decompressor failed: %v", level, err)
+        }
+    }
+}
+
+func TestSnapshotInvalid(t *testing.T) {
+    src := test_data(20000)
+    c, _ := NewCompressor(COMPRESSION_LEVEL_1, STREAMING_BUFFER_100000)
+    c.AppendCompress(nil, src[:10000])
+    snapshot, _ := c.MarshalBinary()
+    counter := binary.LittleEndian.Uint64(snapshot[15:])
+    // The first hash entry follows the counter and the history
+    hash := 15 + 8 + int(counter)
+
+    modify := func(f func(b []byte) []byte) []byte {
+        return f(append([]byte(nil), snapshot...))
+    }
+    tests := []struct {
+        name string
+        data []byte
+    }{
+        {"empty", nil},
+        {"bad magic", modify(func(b []byte) []byte { b[0] ^= 1; return resum(b) })},
+        {"bad version", modify(func(b []byte) []byte { b[4] = _SNAPSHOT_VERSION + 1; return resum(b) })},
+        {"bad checksum", modify(func(b []byte) []byte { b[len(b) - 1] ^= 1; return b })},
+        {"flipped bit", modify(func(b []byte) []byte { b[100] ^= 1; return b })},
+        {"other kind", modify(func(b []byte) []byte { b[5] = _SNAPSHOT_DECOMPRESSOR; return resum(b) })},
+        {"bad level", modify(func(b []byte) []byte { b[6] = 4; return resum(b) })},
+        {"oversized streaming buffer", modify(func(b []byte) []byte {
+            binary.LittleEndian.PutUint64(b[7:], 1 << 50)
+            return resum(b)
+        })},
+        {"counter past the buffer", modify(func(b []byte) []byte {
+            binary.LittleEndian.PutUint64(b[15:], STREAMING_BUFFER_100000 + 1)
+            return resum(b)
+        })},
+        {"offset past the history", modify(func(b []byte) []byte {
+            binary.LittleEndian.PutUint64(b[hash+4:], counter + 10)
+            return resum(b)
+        })},
+        {"truncated", modify(func(b []byte) []byte { return resum(append(b[:hash], 0, 0, 0, 0)) })},
+        {"trailing data", modify(func(b []byte) []byte { return resum(append(b, 0)) })},
+    }
+    for _, tt := range tests {
+        // A failed restore leaves the compressor as it was
+        q := c.Clone()
+        if err := q.UnmarshalBinary(tt.data); !errors.Is(err, ErrInvalidSnapshot) {
+            t.Errorf("%s: got %v, want ErrInvalidSnapshot", tt.name, err)
+        }
+        want, _ := c.Clone().AppendCompress(nil, src[10000:])
+        got, err := q.AppendCompress(nil, src[10000:])
+        if err != nil || !bytes.Equal(got, want) {
+            t.Errorf("%s: compressor changed by a failed restore: %v", tt.name, err)
+        }
+    }
+
+    // The same holds for a decompressor and a Qlz
+    c2, _ := NewCompressor(COMPRESSION_LEVEL_2, STREAMING_BUFFER_100000)
+    block, _ := c2.AppendCompress(nil, src)
+    d, _ := NewDecompressor(COMPRESSION_LEVEL_2, STREAMING_BUFFER_100000)
+    if err := d.UnmarshalBinary(snapshot); !errors.Is(err, ErrInvalidSnapshot) {
+        t.Errorf("decompressor: got %v, want ErrInvalidSnapshot", err)
+    }
+    if out, err := d.AppendDecompress(nil, block); err != nil || !bytes.Equal(out, src) {
+        t.Errorf("decompressor changed by a failed restore: %v", err)
+    }
+    q, _ := New(COMPRESSION_LEVEL_3, STREAMING_BUFFER_0)
+    if err := q.UnmarshalBinary(snapshot); !errors.Is(err, ErrInvalidSnapshot) {
+        t.Errorf("qlz: got %v, want ErrInvalidSnapshot", err)
+    }
+    if q._COMPRESSION_LEVEL != COMPRESSION_LEVEL_3 || q._STREAMING_BUFFER != STREAMING_BUFFER_0 {
+        t.Error("qlz: configuration changed by a failed restore")
+    }
+}
+
+func TestSnapshotDictionary(t *testing.T) {
+    q, err := NewWithDictionary(COMPRESSION_LEVEL_1, STREAMING_BUFFER_100000, test_data(1000))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := q.MarshalBinary(); !errors.Is(err, ErrSnapshotDictionary) {
+        t.Errorf("qlz: got %v, want ErrSnapshotDictionary", err)
+    }
+    if _, err := q.compressor.MarshalBinary(); !errors.Is(err, ErrSnapshotDictionary) {
+        t.Errorf("compressor: got %v, want ErrSnapshotDictionary", err)
+    }
+    if _, err := q.decompressor.MarshalBinary(); !errors.Is(err, ErrSnapshotDictionary) {
+        t.Errorf("decompressor: got %v, want ErrSnapshotDictionary", err)
+    }
+}
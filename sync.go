@@ -0,0 +1,114 @@
+package quicklz
+
+import (
+    "fmt"
+    "sync"
+    "sync/atomic"
+)
+
+// SyncQlz serializes the calls to a Qlz so it can be shared between
+// goroutines
+//
+// In streaming mode the blocks still depend on the order of the calls, the
+// decompressing side has to see them in the order Compress returned them.
+type SyncQlz struct {
+    mu sync.Mutex
+    qlz *Qlz
+}
+
+// Create new goroutine-safe compressor/decompressor
+func NewSync(compression_level uint, streaming_buffer uint) (*SyncQlz, error) {
+    q, err := New(compression_level, streaming_buffer)
+    if err != nil {
+        return nil, err
+    }
+    return q.Locked(), nil
+}
+
+// Wrap q so every call is serialized; q must not be used directly afterwards
+func (q *Qlz) Locked() *SyncQlz {
+    return &SyncQlz{qlz: q}
+}
+
+// Compress the data in source to destination and return the compressed data length
+func (s *SyncQlz) Compress(source, destination *[]byte) (int64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.qlz.Compress(source, destination)
+}
+
+// Decompress the data in source to destination and return the decompressed data length
+func (s *SyncQlz) Decompress(source, destination *[]byte) (int64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.qlz.Decompress(source, destination)
+}
+
+// Compress src and append the compressed block to dst, growing it as needed
+func (s *SyncQlz) AppendCompress(dst, src []byte) ([]byte, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.qlz.AppendCompress(dst, src)
+}
+
+// Decompress the block in src and append the data to dst, growing it as needed
+func (s *SyncQlz) AppendDecompress(dst, src []byte) ([]byte, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.qlz.AppendDecompress(dst, src)
+}
+
+// Reset the compression and decompression history
+func (s *SyncQlz) Reset() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.qlz.Reset()
+}
+
+// Pool spreads independent STREAMING_BUFFER_0 calls over a fixed number of
+// instances, each of them used by one goroutine at a time
+type Pool struct {
+    shards []SyncQlz
+    next uint32
+}
+
+// Create a pool of n STREAMING_BUFFER_0 instances
+func NewPool(compression_level uint, n int) (*Pool, error) {
+    if n <= 0 {
+        return nil, fmt.Errorf("%w: pool size must be positive", ErrInvalidSize)
+    }
+    p := Pool{shards: make([]SyncQlz, n)}
+    for i := range p.shards {
+        q, err := New(compression_level, STREAMING_BUFFER_0)
+        if err != nil {
+            return nil, err
+        }
+        p.shards[i].qlz = q
+    }
+    return &p, nil
+}
+
+func (p *Pool) shard() *SyncQlz {
+    i := atomic.AddUint32(&p.next, 1)
+    return &p.shards[i % uint32(len(p.shards))]
+}
+
+// Compress the data in source to destination and return the compressed data length
+func (p *Pool) Compress(source, destination *[]byte) (int64, error) {
+    return p.shard().Compress(source, destination)
+}
+
+// Decompress the data in source to destination and return the decompressed data length
+func (p *Pool) Decompress(source, destination *[]byte) (int64, error) {
+    return p.shard().Decompress(source, destination)
+}
+
+// Compress src and append the compressed block to dst, growing it as needed
+func (p *Pool) AppendCompress(dst, src []byte) ([]byte, error) {
+    return p.shard().AppendCompress(dst, src)
+}
+
+// Decompress the block in src and append the data to dst, growing it as needed
+func (p *Pool) AppendDecompress(dst, src []byte) ([]byte, error) {
+    return p.shard().AppendDecompress(dst, src)
+}
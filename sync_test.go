@@ -0,0 +1,105 @@
+package quicklz
+
+import (
+    "bytes"
+    "errors"
+    "sync"
+    "testing"
+)
+
+// Data of n bytes, different for every n
+func sync_data(n int) []byte {
+    b := bytes.Repeat([]byte("shared instance, independent blocks\n"), n / 36 + 1)[:n]
+    for i := 0; i < n; i += 64 {
+        b[i] = byte(n + i)
+    }
+    return b
+}
+
+// Run with -race: every goroutine round-trips its own data through the
+// shared instance
+func round_trip_concurrently(t *testing.T, compress, decompress func(dst, src []byte) ([]byte, error)) {
+    var wg sync.WaitGroup
+    for g := 0; g < 8; g++ {
+        wg.Add(1)
+        go func(g int) {
+            defer wg.Done()
+            for i := 0; i < 50; i++ {
+                src := sync_data(1000 + 37 * g + i)
+                block, err := compress(nil, src)
+                if err != nil {
+                    t.Error(err)
+                    return
+                }
+                out, err := decompress(nil, block)
+                if err != nil || !bytes.Equal(out, src) {
+                    t.Errorf("goroutine %d, block %d: %v", g, i, err)
+                    return
+                }
+            }
+        }(g)
+    }
+    wg.Wait()
+}
+
+func TestSyncQlzConcurrent(t *testing.T) {
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        s, err := NewSync(level, STREAMING_BUFFER_0)
+        if err != nil {
+            t.Fatal(err)
+        }
+        round_trip_concurrently(t, s.AppendCompress, s.AppendDecompress)
+    }
+}
+
+func TestSyncQlzStreaming(t *testing.T) {
+    // Blocks are decompressed in the order the shared compressor produced
+    // them, which the lock keeps consistent
+    s, err := NewSync(COMPRESSION_LEVEL_1, STREAMING_BUFFER_100000)
+    if err != nil {
+        t.Fatal(err)
+    }
+    type result struct {
+        src, block []byte
+    }
+    var mu sync.Mutex
+    var results []result
+    var wg sync.WaitGroup
+    for g := 0; g < 8; g++ {
+        wg.Add(1)
+        go func(g int) {
+            defer wg.Done()
+            for i := 0; i < 20; i++ {
+                src := sync_data(2000 + 13 * g + i)
+                mu.Lock()
+                block, err := s.AppendCompress(nil, src)
+                results = append(results, result{src, block})
+                mu.Unlock()
+                if err != nil {
+                    t.Error(err)
+                    return
+                }
+            }
+        }(g)
+    }
+    wg.Wait()
+    for i, r := range results {
+        out, err := s.AppendDecompress(nil, r.block)
+        if err != nil || !bytes.Equal(out, r.src) {
+            t.Fatalf("block %d: %v", i, err)
+        }
+    }
+}
+
+func TestPoolConcurrent(t *testing.T) {
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        p, err := NewPool(level, 4)
+        if err != nil {
+            t.Fatal(err)
+        }
+        round_trip_concurrently(t, p.AppendCompress, p.AppendDecompress)
+    }
+    if _, err := NewPool(COMPRESSION_LEVEL_1, 0); !errors.Is(err, ErrInvalidSize) {
+        t.Fatalf("empty pool: got %v, want ErrInvalidSize", err)
+    }
+}
@@ -0,0 +1,147 @@
+package quicklz
+
+import (
+    "fmt"
+    "io"
+)
+
+const _WRITER_CHUNK = 65536
+
+// Writer compresses the data written to it into a sequence of QuickLZ blocks
+type Writer struct {
+    compressor *Compressor
+    w io.Writer
+    buf []byte
+    dst []byte
+    chunk int
+    err error
+    closed bool
+
+    // Set by framed formats, called after each block is written
+    block func(src, block []byte) error
+}
+
+// Create a streaming compressor that writes QuickLZ blocks to w
+//
+// Written data is buffered and compressed in chunks small enough to keep the
+// history of the streaming buffer, so the output can be read back with a
+// Reader or by calling Decompress on each block.
+func NewWriter(w io.Writer, compression_level uint, streaming_buffer uint) (*Writer, error) {
+    return NewWriterSize(w, compression_level, streaming_buffer, writer_chunk(streaming_buffer))
+}
+
+// Create a streaming compressor that writes blocks of at most chunk bytes of
+// input; the Writer needs a scratch buffer of CompressBound(chunk) bytes
+func NewWriterSize(w io.Writer, compression_level uint, streaming_buffer uint, chunk int) (*Writer, error) {
+    if chunk <= 0 || int64(chunk) > MaxBlockSize {
+        return nil, fmt.Errorf("%w: chunk size %d", ErrInvalidSize, chunk)
+    }
+    c, err := NewCompressor(compression_level, streaming_buffer)
+    if err != nil {
+        return nil, err
+    }
+    z := Writer{
+        compressor: c,
+        w: w,
+        buf: make([]byte, 0, chunk),
+        dst: make([]byte, CompressBound(chunk)),
+        chunk: chunk,
+    }
+    return &z, nil
+}
+
+// A block must fit into the streaming buffer together with the previous one,
+// otherwise Compress starts over without any history.
+func writer_chunk(streaming_buffer uint) int {
+    chunk := _WRITER_CHUNK
+    if streaming_buffer > 0 && int(streaming_buffer / 2) < chunk {
+        chunk = int(streaming_buffer / 2)
+    }
+    if chunk < 1 {
+        chunk = 1
+    }
+    return chunk
+}
+
+// Write compresses p, emitting a block each time a full chunk is buffered
+func (z *Writer) Write(p []byte) (int, error) {
+    if z.closed {
+        return 0, ErrClosed
+    }
+    if z.err != nil {
+        return 0, z.err
+    }
+    n := 0
+    for len(p) > 0 {
+        if len(z.buf) == 0 && len(p) >= z.chunk {
+            part := p[:z.chunk]
+            if err := z.write_block(&part); err != nil {
+                return n, err
+            }
+            n += z.chunk
+            p = p[z.chunk:]
+            continue
+        }
+        m := z.chunk - len(z.buf)
+        if m > len(p) {
+            m = len(p)
+        }
+        z.buf = append(z.buf, p[:m]...)
+        n += m
+        p = p[m:]
+        if len(z.buf) == z.chunk {
+            if err := z.write_block(&z.buf); err != nil {
+                return n, err
+            }
+            z.buf = z.buf[:0]
+        }
+    }
+    return n, nil
+}
+
+// Flush compresses any buffered data and writes it out as a block
+func (z *Writer) Flush() error {
+    if z.closed {
+        return ErrClosed
+    }
+    if z.err != nil {
+        return z.err
+    }
+    if len(z.buf) == 0 {
+        return nil
+    }
+    err := z.write_block(&z.buf)
+    z.buf = z.buf[:0]
+    return err
+}
+
+// Close flushes the remaining data; it does not close the underlying writer
+//
+// Once an error occurred every later call to Close returns it again.
+func (z *Writer) Close() error {
+    if z.closed {
+        return z.err
+    }
+    err := z.Flush()
+    z.closed = true
+    return err
+}
+
+func (z *Writer) write_block(source *[]byte) error {
+    r, err := z.compressor.Compress(source, &z.dst)
+    if err != nil {
+        z.err = err
+        return err
+    }
+    if _, err = z.w.Write(z.dst[:r]); err != nil {
+        z.err = err
+        return err
+    }
+    if z.block != nil {
+        if err = z.block(*source, z.dst[:r]); err != nil {
+            z.err = err
+            return err
+        }
+    }
+    return nil
+}
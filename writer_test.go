@@ -0,0 +1,109 @@
+package quicklz
+
+import (
+    "bytes"
+    "errors"
+    "io/ioutil"
+    "math/rand"
+    "testing"
+)
+
+func TestWriterInvalidChunk(t *testing.T) {
+    for _, chunk := range []int{0, -1} {
+        if _, err := NewWriterSize(ioutil.Discard, COMPRESSION_LEVEL_1, STREAMING_BUFFER_0, chunk); !errors.Is(err, ErrInvalidSize) {
+            t.Fatalf("chunk %d: got %v, want ErrInvalidSize", chunk, err)
+        }
+    }
+}
+
+func TestCompressBoundMonotonic(t *testing.T) {
+    for n := 1; n < 100000; n++ {
+        if CompressBound(n) < CompressBound(n - 1) {
+            t.Fatalf("CompressBound(%d) = %d is less than CompressBound(%d) = %d", n, CompressBound(n), n - 1, CompressBound(n - 1))
+        }
+    }
+}
+
+// The scratch buffer of a Writer is sized for a full chunk, every shorter
+// block flushed from it must fit as well
+func TestWriterFlushEveryLength(t *testing.T) {
+    src := make([]byte, 1000)
+    rand.New(rand.NewSource(1)).Read(src)
+    for _, chunk := range []int{300, 450} {
+        for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+            for n := 1; n <= chunk; n++ {
+                var buf bytes.Buffer
+                w, err := NewWriterSize(&buf, level, STREAMING_BUFFER_0, chunk)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if _, err := w.Write(src[:n]); err != nil {
+                    t.Fatalf("chunk %d, level %d, %d bytes: %v", chunk, level, n, err)
+                }
+                if err := w.Flush(); err != nil {
+                    t.Fatalf("chunk %d, level %d, %d bytes: %v", chunk, level, n, err)
+                }
+                r, err := NewReader(&buf, level, STREAMING_BUFFER_0)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                out, err := ioutil.ReadAll(r)
+                if err != nil || !bytes.Equal(out, src[:n]) {
+                    t.Fatalf("chunk %d, level %d, %d bytes: round trip failed: %v", chunk, level, n, err)
+                }
+            }
+        }
+    }
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+    src := test_data(300000)
+    for _, level := range []uint{COMPRESSION_LEVEL_1, COMPRESSION_LEVEL_2, COMPRESSION_LEVEL_3} {
+        for _, buf := range []uint{STREAMING_BUFFER_0, STREAMING_BUFFER_100000, STREAMING_BUFFER_1000000} {
+            var out bytes.Buffer
+            w, err := NewWriter(&out, level, buf)
+            if err != nil {
+                t.Fatal(err)
+            }
+            // Writes of every size, smaller and larger than a chunk
+            for off, n := 0, 1; off < len(src); off, n = off + n, n * 3 + 1 {
+                if off + n > len(src) {
+                    n = len(src) - off
+                }
+                if m, err := w.Write(src[off:off + n]); err != nil || m != n {
+                    t.Fatalf("level %d, buffer %d: wrote %d of %d bytes: %v", level, buf, m, n, err)
+                }
+            }
+            if err := w.Close(); err != nil {
+                t.Fatal(err)
+            }
+            if _, err := w.Write(src[:1]); !errors.Is(err, ErrClosed) {
+                t.Fatalf("Write after Close: got %v, want ErrClosed", err)
+            }
+            r, _ := NewReader(&out, level, buf)
+            data, err := ioutil.ReadAll(r)
+            if err != nil || !bytes.Equal(data, src) {
+                t.Fatalf("level %d, buffer %d: %v", level, buf, err)
+            }
+        }
+    }
+}
+
+type failing_writer struct{}
+
+var write_error = errors.New("write failed")
+
+func (failing_writer) Write(p []byte) (int, error) {
+    return 0, write_error
+}
+
+func TestWriterCloseError(t *testing.T) {
+    w, _ := NewWriter(failing_writer{}, COMPRESSION_LEVEL_1, STREAMING_BUFFER_0)
+    w.Write(test_data(1000))
+    if err := w.Close(); err != write_error {
+        t.Fatalf("Close: got %v, want the write error", err)
+    }
+    if err := w.Close(); err != write_error {
+        t.Fatalf("second Close: got %v, want the write error", err)
+    }
+}